@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/ihleven/errors"
+	"github.com/ihleven/pkg/httpsrvr"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -16,14 +17,18 @@ type Authentication interface {
 }
 
 func NewAuthentication() Authentication {
-	a := authentication{make(map[string]*Account)}
+	a := authentication{Users: make(map[string]*Account)}
 	a.addAccount(&Matthias)
 	a.addAccount(&Wolfgang)
+	a.loginHandler = CSRF.Middleware(http.HandlerFunc(a.login))
+	a.authenticateHandler = CSRF.Middleware(http.HandlerFunc(a.authenticate))
 	return &a
 }
 
 type authentication struct {
-	Users map[string]*Account
+	Users               map[string]*Account
+	loginHandler        http.Handler // login wrapped in CSRF protection
+	authenticateHandler http.Handler
 }
 
 func (a *authentication) addAccount(account *Account) {
@@ -51,7 +56,12 @@ func (a *authentication) AuthenticateUser(username, password string) (*Account,
 	return nil, errors.NewWithCode(http.StatusUnauthorized, "Invalid credentials")
 }
 
+// Authenticate sets the token cookie for valid form credentials, see CSRF.
 func (a *authentication) Authenticate(w http.ResponseWriter, r *http.Request) {
+	a.authenticateHandler.ServeHTTP(w, r)
+}
+
+func (a *authentication) authenticate(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 	account, err := a.AuthenticateUser(r.PostFormValue("username"), r.PostFormValue("password"))
 	if err != nil {
@@ -71,13 +81,14 @@ func (a *authentication) Authenticate(w http.ResponseWriter, r *http.Request) {
 		Name:     "token",
 		Value:    token,
 		Expires:  expirationTime,
-		Secure:   false, //scheme == "https",
+		Secure:   isHTTPS(r),
 		HttpOnly: true,
 		SameSite: http.SameSiteStrictMode,
 		Path:     "/",
 	})
+	renewCSRF(w, r, token)
 
-	http.Redirect(w, r, "/home", 301)
+	redirect(w, r, HomeRoute, 301)
 	// w.Header().Set("Content-Type", "application/json")
 	// w.WriteHeader(http.StatusOK)
 	// json.NewEncoder(w).Encode(account)
@@ -87,7 +98,12 @@ func (a *authentication) Authenticate(w http.ResponseWriter, r *http.Request) {
 //go:embed templates/*
 var templates embed.FS
 
+// LoginHandler renders the login form and handles its submission, see CSRF.
 func (a *authentication) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	a.loginHandler.ServeHTTP(w, r)
+}
+
+func (a *authentication) login(w http.ResponseWriter, r *http.Request) {
 
 	if r.Method == "POST" {
 		r.ParseForm()
@@ -109,20 +125,21 @@ func (a *authentication) LoginHandler(w http.ResponseWriter, r *http.Request) {
 			Name:     "token",
 			Value:    token,
 			Expires:  expirationTime,
-			Secure:   false, //scheme == "https",
+			Secure:   isHTTPS(r),
 			HttpOnly: true,
 			SameSite: http.SameSiteStrictMode,
 			Path:     "/",
 		})
+		renewCSRF(w, r, token)
 
-		http.Redirect(w, r, "/home", 301)
+		redirect(w, r, HomeRoute, 301)
 		// w.Header().Set("Content-Type", "application/json")
 		// w.WriteHeader(http.StatusOK)
 		// json.NewEncoder(w).Encode(account)
 		return
 	}
 
-	t, err := template.ParseFS(templates, "templates/*.html")
+	t, err := template.New("login").Funcs(TemplateFuncs(r)).ParseFS(templates, "templates/*.html")
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -143,5 +160,12 @@ func (a *authentication) LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	http.SetCookie(w, c)
-	http.Redirect(w, r, "/login", 301)
+	renewCSRF(w, r, "")
+	redirect(w, r, LoginRoute, 301)
+}
+
+// isHTTPS reports whether the client connected via HTTPS, directly or through
+// a proxy trusted with the TrustProxies method of the server.
+func isHTTPS(r *http.Request) bool {
+	return httpsrvr.IsHTTPS(r)
 }
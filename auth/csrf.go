@@ -0,0 +1,271 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ihleven/pkg/httpsrvr"
+)
+
+type csrfKey struct{}
+
+const (
+	csrfCookie = "csrf"
+	csrfHeader = "X-CSRF-Token"
+	csrfField  = "csrf_token"
+)
+
+// CSRF protects the login handlers of this package. Applications use it for
+// their own form handlers, too:
+//
+//	s.Use(auth.CSRF.Middleware)
+var CSRF = NewCSRF()
+
+// CSRFProtection rejects unsafe requests that are not sent by our own pages.
+// Every client gets a random token in the csrf cookie, signed together with
+// its JWT session, which unsafe requests must echo in the csrf_token form field
+// or the X-CSRF-Token header. In addition, the Origin or Referer header must
+// name our own or a trusted origin. JSON requests need no token since browsers
+// send them cross-origin only after a CORS preflight, their origin suffices.
+type CSRFProtection struct {
+	trusted []string
+	exempt  []string
+}
+
+// NewCSRF returns a protection trusting no foreign origins.
+func NewCSRF() *CSRFProtection {
+	return &CSRFProtection{}
+}
+
+// TrustOrigins trusts cross-origin requests from origins like
+// "https://app.example.com". They pass without token since they can't read
+// the cookie; their Origin header is proof enough.
+func (c *CSRFProtection) TrustOrigins(origins ...string) *CSRFProtection {
+
+	for _, origin := range origins {
+		c.trusted = append(c.trusted, strings.ToLower(strings.TrimSuffix(origin, "/")))
+	}
+	return c
+}
+
+// Exempt excludes requests whose path starts with one of prefixes, e.g.
+// APIs authenticated by token instead of cookie. Requests with a bearer token
+// in the Authorization header are always exempt.
+func (c *CSRFProtection) Exempt(prefixes ...string) *CSRFProtection {
+
+	c.exempt = append(c.exempt, prefixes...)
+	return c
+}
+
+// Middleware checks unsafe requests. Requests already checked by another
+// CSRFProtection in the chain pass unchecked.
+func (c *CSRFProtection) Middleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if _, checked := r.Context().Value(csrfKey{}).(string); checked {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session := sessionOf(r)
+		token := ""
+		if cookie, err := r.Cookie(csrfCookie); err == nil && validCSRFToken(cookie.Value, session) {
+			token = cookie.Value
+		}
+
+		if !safeMethod(r.Method) && !c.exempted(r) {
+			origin, ok := c.checkOrigin(r)
+			if !ok {
+				http.Error(w, "CSRF check failed: untrusted origin "+origin, http.StatusForbidden)
+				return
+			}
+			if !c.isTrusted(origin) && !isJSON(r) {
+				submitted := r.Header.Get(csrfHeader)
+				if submitted == "" {
+					submitted = r.PostFormValue(csrfField)
+				}
+				if token == "" || subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+					http.Error(w, "CSRF check failed: missing or invalid token", http.StatusForbidden)
+					return
+				}
+			}
+		}
+
+		if token == "" {
+			token = newCSRFToken(session)
+			setCSRFCookie(w, r, token)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfKey{}, token)))
+	})
+}
+
+func setCSRFCookie(w http.ResponseWriter, r *http.Request, token string) {
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		Path:     "/",
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+		// readable by scripts sending the X-CSRF-Token header
+		HttpOnly: false,
+	})
+}
+
+// renewCSRF issues a token for session, the new value of the JWT cookie set
+// on login or logout. Tokens are bound to the session, without renewal the
+// forms rendered afterwards would fail the check until the cookie expires.
+// A csrf cookie set earlier in the same response is replaced.
+func renewCSRF(w http.ResponseWriter, r *http.Request, session string) {
+
+	h := w.Header()
+	cookies := h["Set-Cookie"]
+	kept := cookies[:0]
+	for _, cookie := range cookies {
+		if !strings.HasPrefix(cookie, csrfCookie+"=") {
+			kept = append(kept, cookie)
+		}
+	}
+	h["Set-Cookie"] = kept
+	setCSRFCookie(w, r, newCSRFToken(session))
+}
+
+// checkOrigin checks the Origin header or, for HTTPS requests without it, the
+// Referer. It returns the origin found.
+func (c *CSRFProtection) checkOrigin(r *http.Request) (string, bool) {
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		referer := r.Header.Get("Referer")
+		if referer == "" {
+			// plain HTTP proxies may strip the Referer, the token suffices then
+			return "", !isHTTPS(r)
+		}
+		u, err := url.Parse(referer)
+		if err != nil || u.Host == "" {
+			return referer, false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	origin = strings.ToLower(origin)
+	if origin == "null" {
+		return origin, false
+	}
+
+	scheme := "http"
+	if isHTTPS(r) {
+		scheme = "https"
+	}
+	if origin == scheme+"://"+strings.ToLower(r.Host) {
+		return origin, true
+	}
+	return origin, c.isTrusted(origin)
+}
+
+func (c *CSRFProtection) isTrusted(origin string) bool {
+
+	for _, trusted := range c.trusted {
+		if origin == trusted {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CSRFProtection) exempted(r *http.Request) bool {
+
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+	// the path of the request URI, the router may have stripped r.URL.Path
+	path := r.URL.Path
+	if u, err := url.ParseRequestURI(r.RequestURI); err == nil {
+		path = u.Path
+	}
+	for _, prefix := range c.exempt {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isJSON reports whether r has a JSON body, which browsers only send
+// cross-origin with CORS.
+func isJSON(r *http.Request) bool {
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions || method == http.MethodTrace
+}
+
+// sessionOf returns the JWT session the token is tied to, empty if anonymous.
+func sessionOf(r *http.Request) string {
+
+	if cookie, err := r.Cookie("token"); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// newCSRFToken returns a random value and its signature for session.
+func newCSRFToken(session string) string {
+
+	value := make([]byte, 32)
+	rand.Read(value)
+	encoded := base64.RawURLEncoding.EncodeToString(value)
+	return encoded + "." + csrfSignature(encoded, session)
+}
+
+func validCSRFToken(token, session string) bool {
+
+	i := strings.IndexByte(token, '.')
+	if i < 0 {
+		return false
+	}
+	return hmac.Equal([]byte(token[i+1:]), []byte(csrfSignature(token[:i], session)))
+}
+
+func csrfSignature(value, session string) string {
+
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte("csrf|" + value + "|" + session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFToken returns the token of the request for the X-CSRF-Token header.
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfKey{}).(string)
+	return token
+}
+
+// CSRFField returns a hidden form field carrying the token of the request.
+func CSRFField(r *http.Request) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` + template.HTMLEscapeString(CSRFToken(r)) + `">`)
+}
+
+// TemplateFuncs returns the per-request template functions csrfField,
+// csrfToken and the CSP nonce:
+//
+//	t, err := template.New("page").Funcs(auth.TemplateFuncs(r)).ParseFS(...)
+func TemplateFuncs(r *http.Request) template.FuncMap {
+
+	return template.FuncMap{
+		"csrfField": func() template.HTML { return CSRFField(r) },
+		"csrfToken": func() string { return CSRFToken(r) },
+		"nonce":     func() string { return httpsrvr.Nonce(r) },
+	}
+}
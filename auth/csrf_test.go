@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// client keeps the cookies of responses like a browser on one origin.
+type client struct {
+	cookies map[string]string
+}
+
+func (c *client) do(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+
+	for name, value := range c.cookies {
+		r.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		c.cookies[cookie.Name] = cookie.Value
+	}
+	return w
+}
+
+func post(path string, form url.Values) *http.Request {
+
+	r := httptest.NewRequest("POST", "http://example.com"+path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Origin", "http://example.com")
+	return r
+}
+
+var csrfInput = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+func TestCSRFLoginThenPost(t *testing.T) {
+
+	a := NewAuthentication()
+	login := http.HandlerFunc(a.LoginHandler)
+	form := CSRF.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFField(r)))
+	}))
+	c := &client{cookies: make(map[string]string)}
+
+	// the login form carries the token of the anonymous session
+	w := c.do(login, httptest.NewRequest("GET", "http://example.com/login", nil))
+	m := csrfInput.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("no token in login form:\n%s", w.Body)
+	}
+	w = c.do(login, post("/login", url.Values{"username": {"matt"}, "password": {"pwd"}, "csrf_token": {m[1]}}))
+	if w.Code != http.StatusMovedPermanently || c.cookies["token"] == "" {
+		t.Fatalf("login failed with %d: %s", w.Code, w.Body)
+	}
+	if n := strings.Count(strings.Join(w.Header()["Set-Cookie"], "\n"), csrfCookie+"="); n != 1 {
+		t.Errorf("%d csrf cookies set on login, want 1", n)
+	}
+
+	// the token of the anonymous session is void now
+	w = c.do(form, post("/form", url.Values{"csrf_token": {m[1]}}))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("pre-login token accepted with %d", w.Code)
+	}
+
+	// the first form rendered after login posts fine
+	w = c.do(form, httptest.NewRequest("GET", "http://example.com/form", nil))
+	m = csrfInput.FindStringSubmatch(w.Body.String())
+	if m == nil {
+		t.Fatalf("no token in form:\n%s", w.Body)
+	}
+	w = c.do(form, post("/form", url.Values{"csrf_token": {m[1]}}))
+	if w.Code != http.StatusOK {
+		t.Errorf("post after login failed with %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestCSRFChecks(t *testing.T) {
+
+	protection := NewCSRF().TrustOrigins("https://app.example.com").Exempt("/api/")
+	h := protection.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// a client with a valid csrf cookie
+	token := newCSRFToken("")
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+		want   int
+	}{
+		{"token in form", func(r *http.Request) {}, http.StatusOK},
+		{"token in header", func(r *http.Request) {
+			r.Body, r.ContentLength = http.NoBody, 0
+			r.Header.Set(csrfHeader, token)
+		}, http.StatusOK},
+		{"missing token", func(r *http.Request) { r.Body, r.ContentLength = http.NoBody, 0 }, http.StatusForbidden},
+		{"wrong token", func(r *http.Request) { r.Header.Set(csrfHeader, newCSRFToken("")) }, http.StatusForbidden},
+		{"foreign origin", func(r *http.Request) { r.Header.Set("Origin", "https://evil.example.com") }, http.StatusForbidden},
+		{"null origin", func(r *http.Request) { r.Header.Set("Origin", "null") }, http.StatusForbidden},
+		{"foreign referer", func(r *http.Request) {
+			r.Header.Del("Origin")
+			r.Header.Set("Referer", "https://evil.example.com/page")
+		}, http.StatusForbidden},
+		{"trusted origin without token", func(r *http.Request) {
+			r.Body, r.ContentLength = http.NoBody, 0
+			r.Header.Set("Origin", "https://app.example.com")
+		}, http.StatusOK},
+		{"JSON without token", func(r *http.Request) {
+			r.Body, r.ContentLength = http.NoBody, 0
+			r.Header.Set("Content-Type", "application/json; charset=utf-8")
+		}, http.StatusOK},
+		{"JSON from foreign origin", func(r *http.Request) {
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Origin", "https://evil.example.com")
+		}, http.StatusForbidden},
+		{"bearer token", func(r *http.Request) {
+			r.Body, r.ContentLength = http.NoBody, 0
+			r.Header.Set("Origin", "https://evil.example.com")
+			r.Header.Set("Authorization", "Bearer x")
+		}, http.StatusOK},
+		{"exempt path", func(r *http.Request) {
+			r.Body, r.ContentLength = http.NoBody, 0
+			r.RequestURI = "/api/jobs"
+		}, http.StatusOK},
+	}
+	for _, test := range tests {
+		r := post("/form", url.Values{"csrf_token": {token}})
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: token})
+		test.modify(r)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d: %s", test.name, w.Code, test.want, w.Body)
+		}
+	}
+}
+
+func TestCSRFCheckedOnce(t *testing.T) {
+
+	calls := 0
+	inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { calls++ })
+	h := CSRF.Middleware(CSRF.Middleware(inner))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	if calls != 1 || len(w.Result().Cookies()) != 1 {
+		t.Errorf("handler called %d times, %d cookies set", calls, len(w.Result().Cookies()))
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+
+	"github.com/ihleven/pkg/httpsrvr"
 )
 
 func Middleware(next http.HandlerFunc) http.HandlerFunc {
@@ -14,11 +16,12 @@ func Middleware(next http.HandlerFunc) http.HandlerFunc {
 		fmt.Printf("*** claims error %d: %v => %v\n", status, claims, err)
 		if err != nil {
 
-			http.Redirect(w, r, "/login", 302)
+			redirect(w, r, LoginRoute, 302)
 			w.WriteHeader(status)
 			fmt.Fprintf(w, "*** claims error %d: %v => %v", status, claims, err)
 		} else {
 			fmt.Printf("*** claims error %d: %v => %v\n", status, claims, err)
+			httpsrvr.SetUser(r, claims.Username)
 			ctx := context.WithValue(r.Context(), "props", claims)
 
 			next.ServeHTTP(w, r.WithContext(ctx))
@@ -26,3 +29,9 @@ func Middleware(next http.HandlerFunc) http.HandlerFunc {
 		}
 	}
 }
+
+// Require is Middleware for use with http.Handler based middleware chains
+// like httpsrvr's dispatcher.Use.
+func Require(next http.Handler) http.Handler {
+	return Middleware(next.ServeHTTP)
+}
@@ -14,7 +14,7 @@ func Handler() http.HandlerFunc {
 
 	tokens := map[string]string{"rex3": "/hochzeit"}
 
-	return func(w http.ResponseWriter, r *http.Request) {
+	return CSRF.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		if r.Method == "POST" {
 			r.ParseForm()
@@ -41,38 +41,38 @@ func Handler() http.HandlerFunc {
 				SameSite: http.SameSiteStrictMode,
 				Path:     "/",
 			})
+			renewCSRF(w, r, token)
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			w.Write([]byte(token))
 		} else {
 			w.Header().Set("Content-Type", "text/html")
-			fmt.Fprintln(w, "<form method='POST'><input name='token' /><button type='submit' /></form>")
+			fmt.Fprintln(w, "<form method='POST'>"+CSRFField(r)+"<input name='token' /><button type='submit' /></form>")
 			return
 		}
 
-	}
+	})).ServeHTTP
 }
 
+// SigninHandler sets the token cookie for valid credentials, posted as form
+// with CSRF token or as JSON. Cross-origin clients need a CORS policy allowing
+// their origin with credentials and must be trusted by CSRF:
+//
+//	cors := httpsrvr.NewCORS().Origins("https://app.example.com").Headers("Content-Type").Credentials(true)
+//	auth.CSRF.TrustOrigins("https://app.example.com")
+//	s.Register("/signin", auth.SigninHandler(users)).Use(cors.Middleware)
 func SigninHandler(users map[string]string) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return CSRF.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Println("signin")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Origin, Accept, token")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST,OPTIONS")
-		w.Header().Set("Access-Control-Allow-Origin", r.Header.Get("Origin"))
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == "GET" || r.Method == "OPTIONS" {
 			// w.WriteHeader(http.StatusOK)
 			w.Header().Set("Content-Type", "text/html")
-			fmt.Fprintln(w, "<form method='POST'><input name='username' /><input name='password' /><button type='submit' /></form>")
+			fmt.Fprintln(w, "<form method='POST'>"+CSRFField(r)+"<input name='username' /><input name='password' /><button type='submit' /></form>")
 			return
 		}
 
-		// fmt.Println("origin:http://192.168.2.114:3000/", r.Header.Get("Origin"), r.Header.Get("X-Forwarded-Proto"))
-		// w.Header().Set("Access-Control-Allow-Origin", "http://192.168.2.114:3000") //r.Header.Get("Origin"))
-		// w.Header().Set("Access-Control-Allow-Credentials", "true")
-
 		var credentials struct {
 			Password string `json:"password"`
 			Username string `json:"username"`
@@ -127,16 +127,17 @@ func SigninHandler(users map[string]string) http.HandlerFunc {
 			Name:     "token",
 			Value:    token,
 			Expires:  expirationTime,
-			Secure:   false, //scheme == "https",
+			Secure:   isHTTPS(r),
 			HttpOnly: true,
 			// SameSite: http.SameSiteStrictMode,
 			Path: "/",
 		})
+		renewCSRF(w, r, token)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(credentials.Username))
-	}
+	})).ServeHTTP
 }
 func Welcome(w http.ResponseWriter, r *http.Request) {
 
@@ -157,4 +158,5 @@ func SignoutHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	http.SetCookie(w, c)
+	renewCSRF(w, r, "")
 }
@@ -0,0 +1,27 @@
+package auth
+
+import "net/http"
+
+// Route names the redirects of this package resolve via URL.
+const (
+	HomeRoute  = "home"
+	LoginRoute = "login"
+)
+
+// URL resolves a route name and parameters to a path. It defaults to "/" + name.
+// Applications using httpsrvr hand over the reverse routing of their server:
+//
+//	auth.URL = srv.URL
+var URL = func(name string, pairs ...interface{}) (string, error) {
+	return "/" + name, nil
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, route string, code int) {
+
+	url, err := URL(route)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, url, code)
+}
@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ihleven/pkg/httpsrvr"
+)
+
+func TestURLHook(t *testing.T) {
+
+	defer func(url func(string, ...interface{}) (string, error)) { URL = url }(URL)
+
+	r := httptest.NewRequest("GET", "/private", nil)
+	w := httptest.NewRecorder()
+	redirect(w, r, LoginRoute, http.StatusFound)
+	if got := w.Header().Get("Location"); got != "/login" {
+		t.Errorf("default: got Location %q", got)
+	}
+
+	srv := httpsrvr.NewServer(0, false)
+	srv.Register("/account/signin", http.NotFoundHandler()).Name(LoginRoute)
+	URL = srv.URL
+
+	tests := []struct {
+		route    string
+		status   int
+		location string
+	}{
+		{LoginRoute, http.StatusFound, "/account/signin"},
+		{HomeRoute, http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		redirect(w, r, test.route, http.StatusFound)
+		if w.Code != test.status || w.Header().Get("Location") != test.location {
+			t.Errorf("%s: got %d %q, want %d %q", test.route, w.Code, w.Header().Get("Location"), test.status, test.location)
+		}
+	}
+
+	// unauthenticated requests are sent to the login route of the server
+	w = httptest.NewRecorder()
+	Middleware(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler reached without token")
+	}).ServeHTTP(w, r)
+	if got := w.Header().Get("Location"); got != "/account/signin" {
+		t.Errorf("middleware: got Location %q", got)
+	}
+}
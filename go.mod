@@ -3,6 +3,7 @@ module github.com/ihleven/pkg
 go 1.16
 
 require (
+	github.com/andybalholm/brotli v1.1.0
 	github.com/coreos/go-systemd v0.0.0-20190719114852-fd7a80b32e1f
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/fatih/color v1.9.0
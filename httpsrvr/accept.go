@@ -0,0 +1,75 @@
+package httpsrvr
+
+import (
+	"strconv"
+	"strings"
+)
+
+// acceptItem is an element of an Accept or Accept-Encoding header.
+type acceptItem struct {
+	value string // media range or content coding in lower case
+	q     float64
+}
+
+// parseAccept parses a header like Accept or Accept-Encoding. Items without
+// or with an invalid q parameter have quality 1.
+func parseAccept(header string) []acceptItem {
+
+	var items []acceptItem
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		item := acceptItem{strings.ToLower(strings.TrimSpace(params[0])), 1}
+		if item.value == "" {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.ToLower(strings.ReplaceAll(param, " ", ""))
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if q, err := strconv.ParseFloat(param[2:], 64); err == nil && q >= 0 && q <= 1 {
+				item.q = q
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// quality returns the quality of offer, a media type or content coding, given
+// by the most specific matching item: offer itself, a range like "text/*" or
+// the wildcard "*/*" or "*". It is 0 if no item matches.
+func quality(items []acceptItem, offer string) float64 {
+
+	q, specificity := 0.0, -1
+	for _, item := range items {
+		s := -1
+		switch {
+		case item.value == offer:
+			s = 2
+		case strings.HasSuffix(item.value, "/*") && strings.HasPrefix(offer, item.value[:len(item.value)-1]):
+			s = 1
+		case item.value == "*" || item.value == "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = item.q, s
+		}
+	}
+	return q
+}
+
+// negotiate returns the offer with the highest quality in header, the first
+// one on ties, or "" if none is acceptable. Callers decide what an empty
+// header means.
+func negotiate(header string, offers ...string) string {
+
+	items := parseAccept(header)
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := quality(items, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,51 @@
+package httpsrvr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAccept(t *testing.T) {
+
+	tests := []struct {
+		header string
+		want   []acceptItem
+	}{
+		{"", nil},
+		{"gzip", []acceptItem{{"gzip", 1}}},
+		{"Text/HTML; level=1; q=0.5, */*;q=0.1", []acceptItem{{"text/html", 0.5}, {"*/*", 0.1}}},
+		{"br;q=0, gzip ; Q = 0.8", []acceptItem{{"br", 0}, {"gzip", 0.8}}},
+		{"a;q=x, b;q=2, c;q=-1", []acceptItem{{"a", 1}, {"b", 1}, {"c", 1}}},
+		{" , gzip,", []acceptItem{{"gzip", 1}}},
+	}
+	for _, test := range tests {
+		if got := parseAccept(test.header); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %v, want %v", test.header, got, test.want)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+
+	tests := []struct {
+		header string
+		offers []string
+		want   string
+	}{
+		{"gzip, br", []string{"br", "gzip"}, "br"},
+		{"gzip;q=1.0, br;q=0.5", []string{"br", "gzip"}, "gzip"},
+		{"br;q=0, *", []string{"br", "gzip"}, "gzip"},
+		{"gzip;q=0", []string{"br", "gzip"}, ""},
+		{"text/html, */*;q=0.8", []string{"application/json", "text/html"}, "text/html"},
+		{"text/*;q=0.5, */*;q=0.1", []string{"application/json", "text/plain"}, "text/plain"},
+		{"text/*, text/plain;q=0", []string{"text/plain", "text/html"}, "text/html"},
+		{"*/*", []string{"text/plain", "text/html"}, "text/plain"},
+		{"image/png", []string{"text/plain", "text/html"}, ""},
+		{"application/problem+json", []string{"application/json", "application/problem+json"}, "application/problem+json"},
+	}
+	for _, test := range tests {
+		if got := negotiate(test.header, test.offers...); got != test.want {
+			t.Errorf("%q %v: got %q, want %q", test.header, test.offers, got, test.want)
+		}
+	}
+}
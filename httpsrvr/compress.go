@@ -0,0 +1,357 @@
+package httpsrvr
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encoder is a streaming compressor as returned by gzip.NewWriter.
+type Encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compression compresses responses with the encoding preferred by the client
+// via Accept-Encoding. br, gzip and deflate are built in, preferred in this
+// order on equal quality. Others like zstd can be added with Encoder:
+//
+//	c := httpsrvr.NewCompression().Encoder("zstd", func(w io.Writer) httpsrvr.Encoder {
+//		e, _ := zstd.NewWriter(w)
+//		return e
+//	})
+//	s.Use(c.Middleware)
+//
+// Bodies smaller than the minimum size, responses with Content-Encoding and
+// already compressed content types are sent unchanged.
+type Compression struct {
+	level     int
+	minSize   int
+	encodings []encoding // added with Encoder, preferred over the built in ones
+	skip      []string   // content type prefixes
+}
+
+type encoding struct {
+	name       string
+	newEncoder func(w io.Writer) Encoder
+}
+
+// NewCompression returns a compression policy using the default level and a
+// minimum body size of 1024 bytes.
+func NewCompression() *Compression {
+
+	return &Compression{
+		level:   gzip.DefaultCompression,
+		minSize: 1024,
+		skip: []string{
+			"image/", "video/", "audio/", "font/woff",
+			"application/zip", "application/gzip", "application/x-gzip", "application/x-brotli",
+			"application/zstd", "application/x-7z-compressed", "application/x-rar-compressed",
+			"application/pdf", "application/octet-stream", "application/wasm",
+		},
+	}
+}
+
+// Level sets the level of the built in encoders, see compress/flate. brotli
+// uses the same level, its default being 6.
+func (c *Compression) Level(level int) *Compression {
+
+	c.level = level
+	return c
+}
+
+// MinSize sets the body size below which responses are not compressed.
+func (c *Compression) MinSize(size int) *Compression {
+
+	c.minSize = size
+	return c
+}
+
+// Skip adds content type prefixes that are never compressed.
+func (c *Compression) Skip(contentTypes ...string) *Compression {
+
+	c.skip = append(c.skip, contentTypes...)
+	return c
+}
+
+// Encoder adds the content coding name, e.g. "zstd", or replaces a built in
+// one. Encodings added later are preferred if the client accepts several with
+// the same quality.
+func (c *Compression) Encoder(name string, newEncoder func(w io.Writer) Encoder) *Compression {
+
+	c.encodings = append([]encoding{{name, newEncoder}}, c.encodings...)
+	return c
+}
+
+func (c *Compression) Middleware(next http.Handler) http.Handler {
+
+	encodings := append(c.encodings[:len(c.encodings):len(c.encodings)],
+		pooledEncoding("br", func(w io.Writer) (Encoder, error) { return brotli.NewWriterLevel(w, brotliLevel(c.level)), nil }),
+		pooledEncoding("gzip", func(w io.Writer) (Encoder, error) { return gzip.NewWriterLevel(w, c.level) }),
+		pooledEncoding("deflate", func(w io.Writer) (Encoder, error) { return zlib.NewWriterLevel(w, c.level) }),
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		addVary(w.Header(), "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), encodings)
+		if enc == nil || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, c: c, encoding: enc, status: http.StatusOK}
+		next.ServeHTTP(cw, r)
+		// not deferred: after a panic the buffered body is dropped
+		cw.close()
+	})
+}
+
+// brotliLevel maps a compress/flate level to a brotli level.
+func brotliLevel(level int) int {
+
+	switch {
+	case level == gzip.DefaultCompression:
+		return brotli.DefaultCompression
+	case level < brotli.BestSpeed:
+		// HuffmanOnly and NoCompression
+		return brotli.BestSpeed
+	}
+	return level
+}
+
+// resettable is implemented by the encoders of compress/gzip, compress/zlib
+// and brotli.
+type resettable interface {
+	Encoder
+	Reset(w io.Writer)
+}
+
+// pooledEncoding returns an encoding recycling its encoders, which are
+// expensive to allocate.
+func pooledEncoding(name string, newEncoder func(w io.Writer) (Encoder, error)) encoding {
+
+	pool := &sync.Pool{}
+	return encoding{name, func(w io.Writer) Encoder {
+		if e, ok := pool.Get().(resettable); ok {
+			e.Reset(w)
+			return pooledEncoder{e, pool}
+		}
+		e, err := newEncoder(w)
+		if err != nil {
+			// invalid level, fall back to the default
+			e = gzip.NewWriter(w)
+			if name == "deflate" {
+				e = zlib.NewWriter(w)
+			}
+		}
+		return pooledEncoder{e.(resettable), pool}
+	}}
+}
+
+type pooledEncoder struct {
+	resettable
+	pool *sync.Pool
+}
+
+func (e pooledEncoder) Close() error {
+
+	err := e.resettable.Close()
+	e.resettable.Reset(nil)
+	e.pool.Put(e.resettable)
+	return err
+}
+
+// negotiateEncoding returns the encoding with the highest quality in the
+// Accept-Encoding header, the first of encodings on ties, or nil.
+func negotiateEncoding(header string, encodings []encoding) *encoding {
+
+	if header == "" {
+		return nil
+	}
+	names := make([]string, len(encodings))
+	for i, enc := range encodings {
+		names[i] = enc.name
+	}
+	name := negotiate(header, names...)
+	for i := range encodings {
+		if encodings[i].name == name {
+			return &encodings[i]
+		}
+	}
+	return nil
+}
+
+// addVary adds value to the Vary header unless it is listed already.
+func addVary(h http.Header, value string) {
+
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
+
+// compressWriter buffers the beginning of the body until it knows whether to
+// compress it: when the buffer reaches the minimum size, on Flush or when the
+// handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	c            *Compression
+	encoding     *encoding
+	encoder      Encoder
+	buf          []byte
+	status       int
+	decided      bool
+	uncompressed uint64
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+
+	if cw.decided || len(cw.buf) > 0 {
+		return
+	}
+	if code < 200 {
+		// informational responses are passed through
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+
+	if !cw.decided {
+		if !cw.compressible() {
+			cw.start(false)
+		} else if len(cw.buf)+len(p) < cw.c.minSize {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		} else {
+			cw.buf = append(cw.buf, p...)
+			return len(p), cw.start(true)
+		}
+	}
+	if cw.encoder != nil {
+		cw.uncompressed += uint64(len(p))
+		return cw.encoder.Write(p)
+	}
+	return cw.ResponseWriter.Write(p)
+}
+
+// compressible reports whether the headers set so far allow compression.
+func (cw *compressWriter) compressible() bool {
+
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := strings.ToLower(h.Get("Content-Type"))
+	if contentType == "image/svg+xml" || strings.HasPrefix(contentType, "image/svg+xml;") {
+		return true
+	}
+	for _, prefix := range cw.c.skip {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// start sends the header and the buffered body, compressed if compress is set.
+func (cw *compressWriter) start(compress bool) error {
+
+	cw.decided = true
+	h := cw.Header()
+	if compress && h.Get("Content-Type") == "" {
+		// sniff before compressing, net/http would sniff the compressed bytes
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+		if !cw.compressible() {
+			compress = false
+		}
+	}
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding.name)
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if compress {
+		cw.encoder = cw.encoding.newEncoder(cw.ResponseWriter)
+		cw.uncompressed += uint64(len(buf))
+		_, err := cw.encoder.Write(buf)
+		return err
+	}
+	if len(buf) > 0 {
+		_, err := cw.ResponseWriter.Write(buf)
+		return err
+	}
+	return nil
+}
+
+// Flush compresses streamed responses regardless of the minimum size.
+func (cw *compressWriter) Flush() {
+
+	if !cw.decided {
+		cw.start(cw.compressible())
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack passes the connection on, the body is not compressed then.
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+
+	cw.decided = true
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return h.Hijack()
+}
+
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close sends a body too small for compression or finishes the compressed
+// one, recording its uncompressed size for the log.
+func (cw *compressWriter) close() {
+
+	if !cw.decided {
+		if len(cw.buf) > 0 || cw.status != http.StatusOK {
+			cw.start(false)
+		}
+		return
+	}
+	if cw.encoder == nil {
+		return
+	}
+	cw.encoder.Close()
+	if rw := unwrapResponseWriter(cw.ResponseWriter); rw != nil {
+		atomic.AddUint64(&rw.uncompressed, cw.uncompressed)
+	}
+}
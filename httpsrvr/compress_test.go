@@ -0,0 +1,230 @@
+package httpsrvr
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/ihleven/pkg/log"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+
+	encodings := []encoding{{name: "br"}, {name: "gzip"}, {name: "deflate"}}
+	tests := []struct {
+		header, want string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"*", "br"},
+		{"*;q=0.5, deflate", "deflate"},
+		{"br;q=0, *", "gzip"},
+		{"gzip;q=0", ""},
+		{"identity", ""},
+		{"GZIP", "gzip"},
+	}
+	for _, test := range tests {
+		got := ""
+		if enc := negotiateEncoding(test.header, encodings); enc != nil {
+			got = enc.name
+		}
+		if got != test.want {
+			t.Errorf("%q: got %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func serveCompressed(c *Compression, method, acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+
+	r := httptest.NewRequest(method, "/", nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	w := httptest.NewRecorder()
+	c.Middleware(h).ServeHTTP(w, r)
+	return w
+}
+
+func TestCompression(t *testing.T) {
+
+	large := strings.Repeat("compressible text ", 200)
+	text := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Length", "999")
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, body)
+		}
+	}
+
+	tests := []struct {
+		name, method, accept string
+		handler              http.HandlerFunc
+		encoding             string
+		etag                 string
+	}{
+		{"gzip", "GET", "gzip", text(large), "gzip", `W/"v1"`},
+		{"brotli preferred", "GET", "gzip, deflate, br", text(large), "br", `W/"v1"`},
+		{"deflate", "GET", "deflate", text(large), "deflate", `W/"v1"`},
+		{"q=0", "GET", "gzip;q=0", text(large), "", `"v1"`},
+		{"no Accept-Encoding", "GET", "", text(large), "", `"v1"`},
+		{"below minSize", "GET", "gzip", text("small"), "", `"v1"`},
+		{"HEAD", "HEAD", "gzip", text(large), "", `"v1"`},
+		{"compressed type", "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, large)
+		}, "", ""},
+		{"sniffed type", "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "<!DOCTYPE html>"+large)
+		}, "gzip", ""},
+		{"Content-Encoding set", "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			bw := brotli.NewWriter(w)
+			io.WriteString(bw, large)
+			bw.Close()
+		}, "br", ""},
+	}
+	for _, test := range tests {
+		w := serveCompressed(NewCompression(), test.method, test.accept, test.handler)
+
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding {
+			t.Errorf("%s: got encoding %q, want %q", test.name, got, test.encoding)
+			continue
+		}
+		if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: got Vary %q", test.name, got)
+		}
+		if got := w.Header().Get("ETag"); got != test.etag {
+			t.Errorf("%s: got ETag %q, want %q", test.name, got, test.etag)
+		}
+
+		if test.encoding != "" && test.encoding != "br" && w.Header().Get("Content-Length") != "" {
+			t.Errorf("%s: Content-Length not removed", test.name)
+		}
+		if test.method == "HEAD" {
+			continue
+		}
+		plain := httptest.NewRecorder()
+		test.handler(plain, httptest.NewRequest("GET", "/", nil))
+		if body, want := decode(t, w), decode(t, plain); body != want {
+			t.Errorf("%s: got body of %d bytes, want %d", test.name, len(body), len(want))
+		}
+	}
+}
+
+// decode returns the body of w decoded according to its Content-Encoding.
+func decode(t *testing.T, w *httptest.ResponseRecorder) string {
+
+	var body io.Reader = w.Body
+	var err error
+	switch w.Header().Get("Content-Encoding") {
+	case "gzip":
+		body, err = gzip.NewReader(body)
+	case "deflate":
+		body, err = zlib.NewReader(body)
+	case "br":
+		body = brotli.NewReader(body)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompressionStatusPassThrough(t *testing.T) {
+
+	for _, code := range []int{http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent} {
+		w := serveCompressed(NewCompression(), "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+			if code == http.StatusPartialContent {
+				io.WriteString(w, strings.Repeat("x", 2000))
+			}
+		})
+		if w.Code != code || w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%d: got %d with encoding %q", code, w.Code, w.Header().Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestCompressionFlush(t *testing.T) {
+
+	w := serveCompressed(NewCompression(), "GET", "gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: 1\n\n")
+		w.(http.Flusher).Flush()
+		if !w.(*compressWriter).decided {
+			t.Error("Flush did not start the response")
+		}
+		io.WriteString(w, "data: 2\n\n")
+	})
+	if !w.Flushed || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("flushed %v with encoding %q, want gzip below minSize", w.Flushed, w.Header().Get("Content-Encoding"))
+	}
+	if body := decode(t, w); body != "data: 1\n\ndata: 2\n\n" {
+		t.Errorf("got body %q", body)
+	}
+}
+
+func TestCompressionCountsUncompressed(t *testing.T) {
+
+	large := strings.Repeat("a", 5000)
+	rw := NewResponseWriter(httptest.NewRecorder())
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	NewCompression().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, large)
+	})).ServeHTTP(rw, r)
+
+	if rw.Uncompressed() != uint64(len(large)) || rw.Count() >= rw.Uncompressed() {
+		t.Errorf("got %d bytes, %d uncompressed", rw.Count(), rw.Uncompressed())
+	}
+}
+
+type entryRecorder struct{ entries []log.AccessEntry }
+
+func (l *entryRecorder) Access(uint64, string, time.Time, string, string, string, string, string, int, int, time.Duration, string, string) {
+}
+
+func (l *entryRecorder) Log(e log.AccessEntry) {
+	l.entries = append(l.entries, e)
+}
+
+func TestCompressionAccessLog(t *testing.T) {
+
+	large := strings.Repeat("compressible ", 200)
+	s := NewServer(0, false)
+	recorder := &entryRecorder{}
+	s.logger = recorder
+	s.Register("/large", NewCompression().Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, large)
+	})))
+	s.Register("/small", func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, "small") })
+
+	for _, path := range []string{"/large", "/small"} {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if len(recorder.entries) != 2 {
+		t.Fatalf("got %d entries", len(recorder.entries))
+	}
+	if e := recorder.entries[0]; e.Uncompressed != len(large) || e.Size >= e.Uncompressed {
+		t.Errorf("compressed: got size %d, uncompressed %d", e.Size, e.Uncompressed)
+	}
+	if e := recorder.entries[1]; e.Size != 5 || e.Uncompressed != 0 {
+		t.Errorf("plain: got size %d, uncompressed %d", e.Size, e.Uncompressed)
+	}
+}
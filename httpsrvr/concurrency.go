@@ -0,0 +1,226 @@
+package httpsrvr
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimit bounds the number of requests served at the same time.
+// Requests exceeding the limit wait in a bounded queue; requests finding the
+// queue full or waiting longer than the queue timeout are answered with 503.
+// Create it with httpServer.ConcurrencyLimit and attach it as middleware to the
+// server or to a dispatcher, limiting its whole subtree:
+//
+//	api := s.ConcurrencyLimit("api", 100, 200).QueueTimeout(2 * time.Second)
+//	s.Register("/api", nil).Use(api.Middleware)
+type ConcurrencyLimit struct {
+	name     string
+	max      int
+	queue    int
+	timeout  time.Duration
+	target   time.Duration // adaptive mode if > 0
+	mu       sync.Mutex
+	inflight int
+	limit    float64 // current limit, lowered in adaptive mode
+	waiters  *list.List
+	lowered  time.Time
+	dropped  int       // rejected, timed out or shed requests since the last report
+	reported time.Time // time of the last report of dropped requests
+	log      logger
+	metrics  *Metrics
+}
+
+// ConcurrencyLimit returns a policy serving at most max requests concurrently
+// with up to queue requests waiting. Its decisions are counted in
+// httpsrvr_concurrency_decisions_total labelled with name and logged at debug
+// level; at info level a summary is logged at most every reportInterval.
+func (s *httpServer) ConcurrencyLimit(name string, max, queue int) *ConcurrencyLimit {
+
+	l := &ConcurrencyLimit{
+		name:    name,
+		max:     max,
+		queue:   queue,
+		limit:   float64(max),
+		waiters: list.New(),
+		log:     s.log,
+		metrics: s.metrics,
+	}
+	labels := Labels("limit", name)
+	s.metrics.Gauge("httpsrvr_concurrency_in_flight"+labels, "Number of requests served within a concurrency limit.", func() float64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return float64(l.inflight)
+	})
+	s.metrics.Gauge("httpsrvr_concurrency_queued"+labels, "Number of requests waiting for a concurrency limit.", func() float64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return float64(l.waiters.Len())
+	})
+	s.metrics.Gauge("httpsrvr_concurrency_limit"+labels, "Current concurrency limit, lowered by adaptive load shedding.", func() float64 {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return float64(int(l.limit))
+	})
+	return l
+}
+
+// QueueTimeout sets how long a request waits for a free slot. Zero means it
+// waits until the client goes away.
+func (l *ConcurrencyLimit) QueueTimeout(timeout time.Duration) *ConcurrencyLimit {
+
+	l.timeout = timeout
+	return l
+}
+
+// Adaptive enables load shedding: while the latency of served requests
+// exceeds target the limit is lowered by 10% per target duration, and it
+// recovers slowly while requests are fast again. Requests above the current
+// limit are not queued but rejected with 503 immediately.
+func (l *ConcurrencyLimit) Adaptive(target time.Duration) *ConcurrencyLimit {
+
+	l.target = target
+	return l
+}
+
+func (l *ConcurrencyLimit) Middleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		decision, ok := l.acquire(r.Context())
+		if decision != "" {
+			l.decided(r, decision)
+		}
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(time.Since(start))
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acquire takes a slot, waiting in the queue if necessary. The decision is
+// empty for requests admitted immediately and "queued", "rejected", "timeout"
+// or "shed" otherwise.
+func (l *ConcurrencyLimit) acquire(ctx context.Context) (decision string, ok bool) {
+
+	l.mu.Lock()
+	if l.inflight < int(l.limit) {
+		l.inflight++
+		l.mu.Unlock()
+		return "", true
+	}
+	if l.target > 0 {
+		l.mu.Unlock()
+		return "shed", false
+	}
+	if l.waiters.Len() >= l.queue {
+		l.mu.Unlock()
+		return "rejected", false
+	}
+	slot := make(chan struct{})
+	e := l.waiters.PushBack(slot)
+	l.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if l.timeout > 0 {
+		timer := time.NewTimer(l.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-slot:
+		return "queued", true
+	case <-timeout:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-slot:
+		// handed over while timing out
+		return "queued", true
+	default:
+		l.waiters.Remove(e)
+		return "timeout", false
+	}
+}
+
+// release frees the slot of a request served in latency, handing it over to
+// the first waiting request if the limit allows.
+func (l *ConcurrencyLimit) release(latency time.Duration) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.adapt(latency)
+	if l.waiters.Len() > 0 && l.inflight <= int(l.limit) {
+		close(l.waiters.Remove(l.waiters.Front()).(chan struct{}))
+		return
+	}
+	l.inflight--
+}
+
+// adapt lowers the limit multiplicatively if latency exceeds the target and
+// raises it additively otherwise.
+func (l *ConcurrencyLimit) adapt(latency time.Duration) {
+
+	if l.target <= 0 {
+		return
+	}
+	now := time.Now()
+	if latency > l.target {
+		if now.Sub(l.lowered) >= l.target && l.limit > 1 {
+			previous := int(l.limit)
+			l.limit *= 0.9
+			if l.limit < 1 {
+				l.limit = 1
+			}
+			l.lowered = now
+			if int(l.limit) != previous {
+				l.log.Info("Concurrency limit %q lowered to %d: latency %v exceeds target %v", l.name, int(l.limit), latency, l.target)
+			}
+		}
+	} else if l.limit < float64(l.max) {
+		l.limit += 1 / l.limit
+		if l.limit > float64(l.max) {
+			l.limit = float64(l.max)
+		}
+	}
+}
+
+// reportInterval bounds how often dropped requests are logged at info level,
+// which would otherwise flood the log under the very overload being limited.
+const reportInterval = 10 * time.Second
+
+// decided logs and counts a decision for r.
+func (l *ConcurrencyLimit) decided(r *http.Request, decision string) {
+
+	l.metrics.Counter("httpsrvr_concurrency_decisions_total"+Labels("limit", l.name, "decision", decision), "Number of requests queued or rejected by concurrency limits.").Inc()
+	if decision == "queued" {
+		return
+	}
+	l.log.Debug("Concurrency limit %q: %s request %s %s %s", l.name, decision, Info(r).ID, r.Method, r.RequestURI)
+
+	l.mu.Lock()
+	l.dropped++
+	now := time.Now()
+	if now.Sub(l.reported) < reportInterval {
+		l.mu.Unlock()
+		return
+	}
+	dropped := l.dropped
+	l.dropped, l.reported = 0, now
+	l.mu.Unlock()
+	l.log.Info("Concurrency limit %q: %d requests dropped since the last report, last one %s", l.name, dropped, decision)
+}
@@ -0,0 +1,70 @@
+package httpsrvr
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordingLogger records log lines by level.
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines map[string][]string
+}
+
+func (l *recordingLogger) record(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.lines == nil {
+		l.lines = make(map[string][]string)
+	}
+	l.lines[level] = append(l.lines[level], fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Debug(format string, args ...interface{}) {
+	l.record("debug", format, args...)
+}
+func (l *recordingLogger) Info(format string, args ...interface{}) { l.record("info", format, args...) }
+func (l *recordingLogger) Fatal(err error, format string, args ...interface{}) {
+	l.record("fatal", format, args...)
+}
+
+func TestConcurrencyLimitRejects(t *testing.T) {
+
+	s := NewServer(0, false)
+	log := &recordingLogger{}
+	s.log = log
+	limit := s.ConcurrencyLimit("api", 1, 0)
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := limit.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		close(done)
+	}()
+	<-entered
+
+	for i := 0; i < 20; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+			t.Fatalf("got %d, Retry-After %q, want 503 with Retry-After", w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+	close(release)
+	<-done
+
+	rejected := s.metrics.Counter("httpsrvr_concurrency_decisions_total"+Labels("limit", "api", "decision", "rejected"), "")
+	if rejected.Value() != 20 {
+		t.Errorf("counted %d rejects, want 20", rejected.Value())
+	}
+	if len(log.lines["debug"]) != 20 || len(log.lines["info"]) != 1 {
+		t.Errorf("logged %d debug and %d info lines, want 20 and 1", len(log.lines["debug"]), len(log.lines["info"]))
+	}
+}
@@ -0,0 +1,257 @@
+package httpsrvr
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS is a cross-origin resource sharing policy. Only origins allowed by the
+// policy are ever sent back in Access-Control-Allow-Origin. It is attached to
+// a server or dispatcher subtree as middleware and answers preflight requests
+// itself:
+//
+//	cors := httpsrvr.NewCORS().Origins("https://app.example.com", "https://*.example.com").Credentials(true)
+//	s.Register("/api", nil).Use(cors.Middleware)
+type CORS struct {
+	any         bool
+	origins     map[string]bool
+	wildcards   []wildcardOrigin
+	patterns    []*regexp.Regexp
+	methods     []string
+	headers     []string // lower case
+	exposed     []string
+	credentials bool
+	maxAge      time.Duration
+}
+
+// wildcardOrigin is an origin like "https://*.example.com" split at the star.
+type wildcardOrigin struct {
+	prefix, suffix string
+}
+
+// NewCORS returns a policy allowing no origins, the methods GET, HEAD and POST
+// and no request headers beyond the CORS-safelisted ones.
+func NewCORS() *CORS {
+
+	return &CORS{
+		origins: make(map[string]bool),
+		methods: []string{http.MethodGet, http.MethodHead, http.MethodPost},
+	}
+}
+
+// Origins allows origins given exactly like "https://example.com", with a
+// wildcard subdomain like "https://*.example.com" or "*" for any origin. The
+// latter cannot be combined with credentials.
+func (c *CORS) Origins(origins ...string) *CORS {
+
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+		switch {
+		case origin == "*":
+			c.any = true
+		case strings.Contains(origin, "*"):
+			i := strings.Index(origin, "*")
+			c.wildcards = append(c.wildcards, wildcardOrigin{origin[:i], origin[i+1:]})
+		default:
+			c.origins[origin] = true
+		}
+	}
+	return c
+}
+
+// OriginPattern allows origins matching the regular expression pattern, which
+// is anchored at both ends. It panics if pattern does not compile.
+func (c *CORS) OriginPattern(pattern string) *CORS {
+
+	c.patterns = append(c.patterns, regexp.MustCompile("^(?:"+pattern+")$"))
+	return c
+}
+
+// Methods sets the allowed methods.
+func (c *CORS) Methods(methods ...string) *CORS {
+
+	c.methods = c.methods[:0]
+	for _, method := range methods {
+		c.methods = append(c.methods, strings.ToUpper(method))
+	}
+	return c
+}
+
+// Headers sets the allowed request headers, "*" allows any.
+func (c *CORS) Headers(headers ...string) *CORS {
+
+	c.headers = c.headers[:0]
+	for _, header := range headers {
+		c.headers = append(c.headers, strings.ToLower(header))
+	}
+	return c
+}
+
+// Expose sets the response headers scripts may read.
+func (c *CORS) Expose(headers ...string) *CORS {
+
+	c.exposed = headers
+	return c
+}
+
+// Credentials allows requests with cookies or HTTP authentication.
+func (c *CORS) Credentials(allowed bool) *CORS {
+
+	c.credentials = allowed
+	return c
+}
+
+// MaxAge sets how long browsers may cache preflight results.
+func (c *CORS) MaxAge(maxAge time.Duration) *CORS {
+
+	c.maxAge = maxAge
+	return c
+}
+
+// Middleware panics if any origin is allowed together with credentials, which
+// would let every site make credentialed requests.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+
+	if c.any && c.credentials {
+		panic("httpsrvr: CORS policy allowing any origin cannot allow credentials")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		h := w.Header()
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		if !c.any {
+			addVary(h, "Origin")
+		}
+		if preflight {
+			addVary(h, "Access-Control-Request-Method")
+			addVary(h, "Access-Control-Request-Headers")
+		}
+		if origin == "" || !c.allowed(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if preflight {
+			c.servePreflight(w, r, origin)
+			return
+		}
+
+		c.allowOrigin(h, origin)
+		if len(c.exposed) > 0 {
+			h.Set("Access-Control-Expose-Headers", strings.Join(c.exposed, ", "))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// servePreflight answers a preflight request, without CORS headers if the
+// requested method or headers are not allowed.
+func (c *CORS) servePreflight(w http.ResponseWriter, r *http.Request, origin string) {
+
+	method := strings.ToUpper(r.Header.Get("Access-Control-Request-Method"))
+	if !c.methodAllowed(method) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	requested := splitHeaderList(r.Header.Values("Access-Control-Request-Headers"))
+	for _, header := range requested {
+		if !c.headerAllowed(header) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	h := w.Header()
+	c.allowOrigin(h, origin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(c.methods, ", "))
+	if len(requested) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+	}
+	if c.maxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(c.maxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (c *CORS) allowOrigin(h http.Header, origin string) {
+
+	if c.any {
+		h.Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", origin)
+	if c.credentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// allowed reports whether the policy allows origin.
+func (c *CORS) allowed(origin string) bool {
+
+	if c.any {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if c.origins[origin] {
+		return true
+	}
+	for _, w := range c.wildcards {
+		if len(origin) > len(w.prefix)+len(w.suffix) && strings.HasPrefix(origin, w.prefix) && strings.HasSuffix(origin, w.suffix) {
+			// the star only covers subdomain labels
+			if sub := origin[len(w.prefix) : len(origin)-len(w.suffix)]; !strings.ContainsAny(sub, "/:@") {
+				return true
+			}
+		}
+	}
+	for _, re := range c.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORS) methodAllowed(method string) bool {
+
+	for _, m := range c.methods {
+		if m == method {
+			return true
+		}
+	}
+	// safelisted methods need no permission
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodPost
+}
+
+func (c *CORS) headerAllowed(header string) bool {
+
+	for _, h := range c.headers {
+		if h == header || (h == "*" && header != "authorization") {
+			return true
+		}
+	}
+	return false
+}
+
+// splitHeaderList splits comma separated header values into lower case names.
+func splitHeaderList(values []string) []string {
+
+	var names []string
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,114 @@
+package httpsrvr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCORS(t *testing.T) {
+
+	cors := NewCORS().
+		Origins("https://app.example.com", "https://*.example.org").
+		OriginPattern(`http://localhost:\d+`).
+		Methods("GET", "PUT").
+		Headers("Content-Type", "X-Token").
+		Expose("X-Request-Id").
+		Credentials(true).
+		MaxAge(10 * time.Minute)
+
+	tests := []struct {
+		name, method, origin    string
+		requestMethod, headers  string
+		status                  int
+		allowOrigin, allowHeads string
+		vary                    []string
+	}{
+		{"same origin", "GET", "", "", "", 200, "", "", []string{"Origin"}},
+		{"allowed", "GET", "https://app.example.com", "", "", 200, "https://app.example.com", "", []string{"Origin"}},
+		{"case", "GET", "HTTPS://App.Example.com", "", "", 200, "HTTPS://App.Example.com", "", []string{"Origin"}},
+		{"wildcard", "GET", "https://a.b.example.org", "", "", 200, "https://a.b.example.org", "", []string{"Origin"}},
+		{"wildcard without subdomain", "GET", "https://example.org", "", "", 200, "", "", []string{"Origin"}},
+		{"wildcard with port", "GET", "https://evil.com:1@x.example.org", "", "", 200, "", "", []string{"Origin"}},
+		{"pattern", "GET", "http://localhost:3000", "", "", 200, "http://localhost:3000", "", []string{"Origin"}},
+		{"pattern anchored", "GET", "http://localhost:3000.evil.com", "", "", 200, "", "", []string{"Origin"}},
+		{"disallowed", "GET", "https://evil.com", "", "", 200, "", "", []string{"Origin"}},
+		{"preflight", "OPTIONS", "https://app.example.com", "PUT", "content-type, X-Token", 204, "https://app.example.com", "content-type, x-token", []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{"preflight safelisted method", "OPTIONS", "https://app.example.com", "POST", "", 204, "https://app.example.com", "", []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{"preflight method denied", "OPTIONS", "https://app.example.com", "DELETE", "", 204, "", "", []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{"preflight header denied", "OPTIONS", "https://app.example.com", "PUT", "Authorization", 204, "", "", []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{"preflight origin denied", "OPTIONS", "https://evil.com", "PUT", "", 204, "", "", []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}},
+		{"plain OPTIONS", "OPTIONS", "https://app.example.com", "", "", 200, "https://app.example.com", "", []string{"Origin"}},
+	}
+
+	for _, test := range tests {
+		var reached bool
+		h := cors.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { reached = true }))
+		r := httptest.NewRequest(test.method, "/api", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.requestMethod != "" {
+			r.Header.Set("Access-Control-Request-Method", test.requestMethod)
+		}
+		if test.headers != "" {
+			r.Header.Set("Access-Control-Request-Headers", test.headers)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		preflight := test.requestMethod != ""
+		header := w.Header()
+		if w.Code != test.status || reached == preflight {
+			t.Errorf("%s: got %d, handler reached %v", test.name, w.Code, reached)
+		}
+		if got := header.Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+			t.Errorf("%s: got Allow-Origin %q, want %q", test.name, got, test.allowOrigin)
+		}
+		if got := header.Get("Access-Control-Allow-Headers"); got != test.allowHeads {
+			t.Errorf("%s: got Allow-Headers %q, want %q", test.name, got, test.allowHeads)
+		}
+		if got := header.Values("Vary"); !reflect.DeepEqual(got, test.vary) {
+			t.Errorf("%s: got Vary %q, want %q", test.name, got, test.vary)
+		}
+
+		allowed := test.allowOrigin != ""
+		if got := header.Get("Access-Control-Allow-Credentials") == "true"; got != allowed {
+			t.Errorf("%s: got Allow-Credentials %v", test.name, got)
+		}
+		if got := header.Get("Access-Control-Expose-Headers") != ""; got != (allowed && !preflight) {
+			t.Errorf("%s: got Expose-Headers %q", test.name, header.Get("Access-Control-Expose-Headers"))
+		}
+		if got := header.Get("Access-Control-Max-Age"); allowed && preflight && got != "600" {
+			t.Errorf("%s: got Max-Age %q", test.name, got)
+		}
+		if got := header.Get("Access-Control-Allow-Methods"); allowed && preflight && got != "GET, PUT" {
+			t.Errorf("%s: got Allow-Methods %q", test.name, got)
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+
+	h := NewCORS().Origins("*").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Origin", "https://anywhere.com")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got Allow-Origin %q", got)
+	}
+	// the response does not depend on the origin
+	if vary := w.Header().Values("Vary"); len(vary) != 0 {
+		t.Errorf("got Vary %q", vary)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic allowing any origin with credentials")
+		}
+	}()
+	NewCORS().Origins("*").Credentials(true).Middleware(http.NotFoundHandler())
+}
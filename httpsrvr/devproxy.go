@@ -0,0 +1,47 @@
+package httpsrvr
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+)
+
+// DevProxy forwards all requests not matched by a registered route to the
+// development server at target, e.g. "http://localhost:3000", so a JS frontend
+// and the API share one origin during development. WebSocket upgrades used for
+// hot reloading are passed through. DevProxy has no effect unless the server
+// runs in debug mode.
+func (s *httpServer) DevProxy(target string) *httpServer {
+
+	if !s.debug {
+		s.log.Info("Ignoring dev proxy to %s outside debug mode", target)
+		return s
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		s.log.Fatal(err, "Invalid dev proxy target %q", target)
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(u)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		// dev servers tend to check the host header
+		r.Host = u.Host
+	}
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		s.log.Info("Dev proxy to %s failed: %v", target, err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+	}
+
+	s.devProxy = proxy
+	s.log.Debug("Proxying unmatched routes to %s", target)
+	return s
+}
+
+// unmatched reports whether d is a root dispatcher without handler, i.e. no
+// registered route matched the request.
+func (d *dispatcher) unmatched() bool {
+	return d.pattern == "/" && !d.any && len(d.methods) == 0
+}
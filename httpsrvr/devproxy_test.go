@@ -0,0 +1,80 @@
+package httpsrvr
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDevProxy(t *testing.T) {
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "upstream %s %s", r.Host, r.URL.Path)
+	}))
+	defer upstream.Close()
+	host := upstream.Listener.Addr().String()
+
+	s := NewServer(0, true).DevProxy(upstream.URL)
+	s.Register("/api/users", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "users") })
+	s.Register("/api/health", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "ok") })
+
+	tests := []struct {
+		path, want string
+	}{
+		{"/api/users", "users"},
+		{"/api/health", "ok"},
+		{"/", "upstream " + host + " /"},
+		{"/src/main.js", "upstream " + host + " /src/main.js"},
+		{"/api", "upstream " + host + " /api"},
+		{"/api/unknown", "upstream " + host + " /api/unknown"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Body.String() != test.want {
+			t.Errorf("%s: got %d %q, want %q", test.path, w.Code, w.Body, test.want)
+		}
+	}
+}
+
+func TestDevProxyRootRoute(t *testing.T) {
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "upstream")
+	}))
+	defer upstream.Close()
+
+	// a handler registered for the root catches everything, nothing is proxied
+	s := NewServer(0, true).DevProxy(upstream.URL)
+	s.Register("/", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "root") })
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/src/main.js", nil))
+	if w.Body.String() != "root" {
+		t.Errorf("got %q", w.Body)
+	}
+}
+
+func TestDevProxyUnavailable(t *testing.T) {
+
+	upstream := httptest.NewServer(http.NotFoundHandler())
+	url := upstream.URL
+	upstream.Close()
+
+	s := NewServer(0, true).DevProxy(url)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/src/main.js", nil))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("got %d, want 502", w.Code)
+	}
+}
+
+func TestDevProxyDebugOnly(t *testing.T) {
+
+	s := NewServer(0, false).DevProxy("http://localhost:3000")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/src/main.js", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("got %d, want 404", w.Code)
+	}
+}
@@ -1,23 +1,38 @@
 package httpsrvr
 
 import (
+	"fmt"
 	"net/http"
-	"path"
+	"sort"
 	"strings"
 )
 
+// NewDispatcher returns the root dispatcher of a new route tree.
 func NewDispatcher(handler http.Handler, name string) *dispatcher {
 	if handler == nil {
 		handler = http.NotFoundHandler()
 	}
-	return &dispatcher{name: name, handler: handler, children: make(map[string]*dispatcher)}
+	t := &tree{root: &node{}}
+	d := &dispatcher{tree: t, node: t.root, pattern: "/", name: name, handler: handler}
+	t.root.route = d
+	t.compile()
+	return d
 }
 
 type dispatcher struct {
+	tree     *tree
+	node     *node
+	parent   *dispatcher // nearest dispatcher above, maintained by tree.compile
+	pattern  string      // path of the dispatcher relative to the tree root
 	name     string
 	handler  http.Handler
-	children map[string]*dispatcher
+	methods  map[string]http.Handler
+	any      bool // handler was registered explicitly and serves every method without a method handler
+	allow    string
 	preserve bool
+	use      []Middleware
+	isolated bool         // middleware of parent dispatchers does not apply
+	serve    http.Handler // method dispatch wrapped in middleware, maintained by tree.compile
 }
 
 func (r *dispatcher) PreservePath(preserve bool) *dispatcher {
@@ -32,48 +47,157 @@ func (r *dispatcher) Name(name string) *dispatcher {
 	return r
 }
 
+// Register connects handler to path below r. Segments of the form {name},
+// {name:int} or {name:regexp} match any, an integer or a regexp constrained
+// segment and make its value available via Param.
+// Literal segments take precedence over constrained parameters which take
+// precedence over plain parameters, the next one is tried if a segment leads
+// to no route. Parameters don't match empty segments.
+// Requests for paths below a route without a more specific route are served by
+// that route with the remainder as path.
+// The route is named after the last segment of path, see Name.
+// A nil handler only creates the route, e.g. for binding method handlers.
 func (r *dispatcher) Register(path string, handler http.Handler) *dispatcher {
 
-	head, tail := shiftPath(path)
+	rel, _ := splitPattern(path)
+	pattern, segments := splitPattern(r.pattern + rel)
 
-	switch {
-	case path == "/":
-		// root level
+	n := r.tree.root.insert(segments)
+	if n.route == nil {
+		n.route = &dispatcher{tree: r.tree, node: n, pattern: pattern, name: rel[strings.LastIndexByte(rel, '/')+1:], handler: http.NotFoundHandler()}
+	}
+	n.route.setHandler(handler)
+	r.tree.compile()
+	return n.route
+}
+
+func (r *dispatcher) setHandler(handler http.Handler) {
+	if handler != nil {
 		r.handler = handler
-		return r
-	case tail == "/":
-		// child route
-		r.children[head] = NewDispatcher(handler, path[1:]) // {children: make(map[string]*dispatcher), handler: handler}
-		return r.children[head]
-
-	default:
-		// nested child route
-		if _, ok := r.children[head]; !ok {
-			r.children[head] = NewDispatcher(r.handler, path) // r.handler -> notfound handler
-		}
-		return r.children[head].Register(tail, handler)
+		r.any = true
 	}
 }
 
-func (d *dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	d.handler.ServeHTTP(w, r)
+// Method binds handler to requests with the given HTTP method. handler may be
+// of any type accepted by httpServer.Register.
+// As soon as a method handler is bound, requests with other methods are
+// answered with 405 Method Not Allowed unless a handler was given to Register.
+// HEAD falls back to the GET handler, OPTIONS is answered automatically.
+// Method panics if handler is nil or of an unknown type.
+func (r *dispatcher) Method(method string, handler interface{}) *dispatcher {
+
+	h, ok := toHandler(handler)
+	if ok && h == nil {
+		panic(fmt.Sprintf("httpsrvr: could not bind %s handler to route '%v': handler is nil", method, r.name))
+	}
+	if !ok {
+		panic(fmt.Sprintf("httpsrvr: could not bind %s handler to route '%v': unknown handler type %T", method, r.name, handler))
+	}
+	if r.methods == nil {
+		r.methods = make(map[string]http.Handler)
+	}
+	r.methods[strings.ToUpper(method)] = h
+	r.tree.compile()
+	return r
+}
+
+func (r *dispatcher) Get(handler interface{}) *dispatcher {
+	return r.Method(http.MethodGet, handler)
+}
+
+func (r *dispatcher) Post(handler interface{}) *dispatcher {
+	return r.Method(http.MethodPost, handler)
+}
+
+func (r *dispatcher) Put(handler interface{}) *dispatcher {
+	return r.Method(http.MethodPut, handler)
+}
+
+func (r *dispatcher) Patch(handler interface{}) *dispatcher {
+	return r.Method(http.MethodPatch, handler)
+}
+
+func (r *dispatcher) Delete(handler interface{}) *dispatcher {
+	return r.Method(http.MethodDelete, handler)
+}
+
+// Allowed returns the sorted methods served by the dispatcher or nil if it serves any method.
+func (r *dispatcher) Allowed() []string {
+
+	if len(r.methods) == 0 || r.any {
+		return nil
+	}
+	allowed := []string{http.MethodOptions}
+	for method := range r.methods {
+		if method != http.MethodOptions {
+			allowed = append(allowed, method)
+		}
+	}
+	if _, ok := r.methods[http.MethodHead]; !ok && r.methods[http.MethodGet] != nil {
+		allowed = append(allowed, http.MethodHead)
+	}
+	sort.Strings(allowed)
+	return allowed
 }
 
-func (d *dispatcher) GetDispatcher(route string) (*dispatcher, string) {
+// methodHandler selects the handler for the request method.
+func (d *dispatcher) methodHandler(method string) http.Handler {
 
-	head, tail := shiftPath(route)
+	if len(d.methods) == 0 {
+		return d.handler
+	}
+	if h, ok := d.methods[method]; ok {
+		return h
+	}
+	if h, ok := d.methods[http.MethodGet]; ok && method == http.MethodHead {
+		return h
+	}
+	if d.any {
+		return d.handler
+	}
 
-	if disp, ok := d.children[head]; ok {
-		return disp.GetDispatcher(tail)
+	if method == http.MethodOptions {
+		return http.HandlerFunc(d.serveOptions)
 	}
-	return d, route
+	return http.HandlerFunc(d.serveMethodNotAllowed)
+}
+
+func (d *dispatcher) serveOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", d.allow)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func shiftPath(p string) (head, tail string) {
-	p = path.Clean("/" + p)
-	i := strings.Index(p[1:], "/") + 1
-	if i <= 0 {
-		return p[1:], "/"
+func (d *dispatcher) serveMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Allow", d.allow)
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+func (d *dispatcher) serveMethod(w http.ResponseWriter, r *http.Request) {
+	d.methodHandler(r.Method).ServeHTTP(w, r)
+}
+
+// ServeHTTP serves the request through the middleware of d and its parents.
+func (d *dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.serve.ServeHTTP(w, r)
+}
+
+// GetDispatcher returns the dispatcher matching route, the unmatched remainder
+// of route and the parameters collected on the way.
+func (d *dispatcher) GetDispatcher(route string) (*dispatcher, string, PathParams) {
+	return d.match(route)
+}
+
+// match is GetDispatcher collecting the parameters in a pooled slice, which
+// only allocates for the copy returned if there are any.
+func (d *dispatcher) match(route string) (*dispatcher, string, PathParams) {
+
+	scratch := paramsPool.Get().(*PathParams)
+	disp, tail := d.node.match(cleanPath(route), scratch)
+	var params PathParams
+	if len(*scratch) > 0 {
+		params = append(params, *scratch...)
 	}
-	return p[1:i], p[i:]
+	*scratch = (*scratch)[:0]
+	paramsPool.Put(scratch)
+	return disp, tail, params
 }
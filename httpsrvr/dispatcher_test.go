@@ -0,0 +1,255 @@
+package httpsrvr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+func benchmarkRoutes() *dispatcher {
+
+	h := http.NotFoundHandler()
+	root := NewDispatcher(nil, "root")
+	root.Register("/", h)
+	root.Register("/static/css/main", h)
+	root.Register("/static/js", h)
+	root.Register("/users", h)
+	root.Register("/users/new", h)
+	root.Register("/users/{id:int}", h)
+	root.Register("/users/{id:int}/photos/{photo}", h)
+	root.Register("/users/{slug:[a-z-]+}/profile", h)
+	root.Register("/admin/settings/mail", h)
+	return root
+}
+
+func TestMatch(t *testing.T) {
+
+	root := benchmarkRoutes()
+	tests := []struct {
+		path, pattern, tail string
+		params              PathParams
+	}{
+		{"/", "/", "/", nil},
+		{"/unknown/x", "/", "/unknown/x", nil},
+		{"/static/css/main/site.css", "/static/css/main", "/site.css", nil},
+		{"/static/css/other.css", "/", "/static/css/other.css", nil},
+		{"/users/", "/users", "/", nil},
+		{"/users/new", "/users/new", "/", nil},
+		{"/users/42", "/users/{id:int}", "/", PathParams{{"id", "42"}}},
+		{"/users/42/photos/p1/large", "/users/{id:int}/photos/{photo}", "/large", PathParams{{"id", "42"}, {"photo", "p1"}}},
+		{"/users/42/photos", "/users/{id:int}", "/photos", PathParams{{"id", "42"}}},
+		{"/users/jane-doe/profile", "/users/{slug:[a-z-]+}/profile", "/", PathParams{{"slug", "jane-doe"}}},
+		{"/users/Jane/profile", "/users", "/Jane/profile", nil},
+		{"/users//42/./photos/../", "/users/{id:int}", "/", PathParams{{"id", "42"}}},
+	}
+	for _, test := range tests {
+		d, tail, params := root.GetDispatcher(test.path)
+		if d.pattern != test.pattern || tail != test.tail || len(params) != len(test.params) {
+			t.Errorf("%s: got %s %q %v, want %s %q %v", test.path, d.pattern, tail, params, test.pattern, test.tail, test.params)
+			continue
+		}
+		for i := range params {
+			if params[i] != test.params[i] {
+				t.Errorf("%s: got params %v, want %v", test.path, params, test.params)
+			}
+		}
+	}
+}
+
+func TestMatchBacktracking(t *testing.T) {
+
+	h := http.NotFoundHandler()
+	root := NewDispatcher(nil, "root")
+	root.Register("/users", h)
+	root.Register("/users/{id}", h)
+	root.Register("/users/new/profile", h)
+	root.Register("/files/{name:[a-z]+}/raw", h)
+	root.Register("/files/{path}", h)
+
+	tests := []struct {
+		path, pattern, tail string
+		params              PathParams
+	}{
+		// a plain parameter doesn't match the empty segment after a trailing slash
+		{"/users/", "/users", "/", nil},
+		// the compressed literal /new/profile doesn't match, the parameter does
+		{"/users/new", "/users/{id}", "/", PathParams{{"id", "new"}}},
+		{"/users/new/profile", "/users/new/profile", "/", nil},
+		{"/users/new/other", "/users/{id}", "/other", PathParams{{"id", "new"}}},
+		// the constrained parameter leads to no route, the plain one does
+		{"/files/abc", "/files/{path}", "/", PathParams{{"path", "abc"}}},
+		{"/files/abc/raw", "/files/{name:[a-z]+}/raw", "/", PathParams{{"name", "abc"}}},
+	}
+	for _, test := range tests {
+		d, tail, params := root.GetDispatcher(test.path)
+		if d.pattern != test.pattern || tail != test.tail || len(params) != len(test.params) {
+			t.Errorf("%s: got %s %q %v, want %s %q %v", test.path, d.pattern, tail, params, test.pattern, test.tail, test.params)
+			continue
+		}
+		for i := range params {
+			if params[i] != test.params[i] {
+				t.Errorf("%s: got params %v, want %v", test.path, params, test.params)
+			}
+		}
+	}
+}
+
+func TestRouteNames(t *testing.T) {
+
+	root := NewDispatcher(nil, "root")
+	for path, name := range map[string]string{"/users": "users", "/users/{id}/photos": "photos", "/a/b/": "b"} {
+		if d := root.Register(path, http.NotFoundHandler()); d.name != name {
+			t.Errorf("%s: got name %q, want %q", path, d.name, name)
+		}
+	}
+}
+
+func TestMatchAllocations(t *testing.T) {
+
+	root := benchmarkRoutes()
+	params := make(PathParams, 0, 8)
+	for _, path := range []string{"/static/css/main/site.css", "/users/42/photos/p1"} {
+		allocs := testing.AllocsPerRun(100, func() {
+			params = params[:0]
+			root.node.match(cleanPath(path), &params)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: %v allocations per match", path, allocs)
+		}
+	}
+}
+
+func benchmarkMatch(b *testing.B, path string) {
+
+	root := benchmarkRoutes()
+	params := make(PathParams, 0, 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		params = params[:0]
+		root.node.match(cleanPath(path), &params)
+	}
+}
+
+func BenchmarkMatchStatic(b *testing.B) {
+	benchmarkMatch(b, "/static/css/main/site.css")
+}
+
+func BenchmarkMatchParams(b *testing.B) {
+	benchmarkMatch(b, "/users/42/photos/p1")
+}
+
+func BenchmarkMatchRegexp(b *testing.B) {
+	benchmarkMatch(b, "/users/jane-doe/profile")
+}
+
+type discardAccessLog struct{}
+
+func (discardAccessLog) Access(uint64, string, time.Time, string, string, string, string, string, int, int, time.Duration, string, string) {
+}
+
+// BenchmarkServeHTTP measures a whole request through the server, which
+// unlike matching allocates, e.g. for the request id and context.
+func BenchmarkServeHTTP(b *testing.B) {
+
+	color.Output = io.Discard
+	s := NewServer(0, false)
+	s.logger = discardAccessLog{}
+	s.Register("/users/{id:int}", func(w http.ResponseWriter, r *http.Request) {})
+	r := httptest.NewRequest("GET", "/users/42", nil)
+	w := httptest.NewRecorder()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.URL.Path = "/users/42"
+		s.ServeHTTP(w, r)
+	}
+}
+
+func TestMethodNilHandler(t *testing.T) {
+
+	var nilFunc func(http.ResponseWriter, *http.Request)
+	for _, handler := range []interface{}{nil, nilFunc} {
+		func() {
+			defer func() {
+				if v := recover(); v == nil || !strings.Contains(fmt.Sprint(v), "handler is nil") {
+					t.Errorf("%T: got panic %v, want nil handler panic", handler, v)
+				}
+			}()
+			NewDispatcher(nil, "root").Register("/users", nil).Get(handler)
+		}()
+	}
+}
+
+func TestMethods(t *testing.T) {
+
+	reply := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, body) }
+	}
+	root := NewDispatcher(nil, "root")
+	root.Register("/items", nil).Get(reply("get")).Post(reply("post"))
+	root.Register("/mixed", reply("any")).Delete(reply("delete"))
+	root.Register("/plain", reply("plain"))
+	root.Register("/options", nil).Get(reply("get")).Method("options", reply("options"))
+	root.Register("/head", nil).Get(reply("get")).Method("HEAD", reply("head"))
+	root.Register("/later", reply("any"))
+	root.Register("/later", nil).Put(reply("put"))
+
+	tests := []struct {
+		method, path string
+		status       int
+		allow, body  string
+	}{
+		{"GET", "/items", 200, "", "get"},
+		{"POST", "/items", 200, "", "post"},
+		{"HEAD", "/items", 200, "", "get"},
+		{"PUT", "/items", 405, "GET, HEAD, OPTIONS, POST", "Method Not Allowed\n"},
+		{"OPTIONS", "/items", 204, "GET, HEAD, OPTIONS, POST", ""},
+		{"DELETE", "/mixed", 200, "", "delete"},
+		{"GET", "/mixed", 200, "", "any"},
+		{"OPTIONS", "/mixed", 200, "", "any"},
+		{"PATCH", "/plain", 200, "", "plain"},
+		{"OPTIONS", "/plain", 200, "", "plain"},
+		{"OPTIONS", "/options", 200, "", "options"},
+		{"DELETE", "/options", 405, "GET, HEAD, OPTIONS", "Method Not Allowed\n"},
+		{"HEAD", "/head", 200, "", "head"},
+		{"DELETE", "/head", 405, "GET, HEAD, OPTIONS", "Method Not Allowed\n"},
+		{"PUT", "/later", 200, "", "put"},
+		{"GET", "/later", 200, "", "any"},
+	}
+	for _, test := range tests {
+		d, _, _ := root.GetDispatcher(test.path)
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.status || w.Header().Get("Allow") != test.allow || w.Body.String() != test.body {
+			t.Errorf("%s %s: got %d %q %q, want %d %q %q", test.method, test.path, w.Code, w.Header().Get("Allow"), w.Body, test.status, test.allow, test.body)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+
+	root := NewDispatcher(nil, "root")
+	h := http.NotFoundHandler()
+	tests := []struct {
+		d    *dispatcher
+		want string
+	}{
+		{root.Register("/a", h), ""},
+		{root.Register("/b", nil).Get(h), "GET HEAD OPTIONS"},
+		{root.Register("/c", nil).Post(h).Put(h), "OPTIONS POST PUT"},
+		{root.Register("/d", h).Post(h), ""},
+	}
+	for _, test := range tests {
+		if got := strings.Join(test.d.Allowed(), " "); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.d.pattern, got, test.want)
+		}
+	}
+}
@@ -1,8 +1,8 @@
 package httpsrvr
 
 import (
+	"encoding/json"
 	"fmt"
-	"math"
 	"net/http"
 
 	"github.com/ihleven/errors"
@@ -12,28 +12,92 @@ type ErrorHandler func(http.ResponseWriter, *http.Request) error
 
 func (h ErrorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	debug := r.Context().Value("debug").(bool)
-
-	err := h(w, r)
-	if err != nil {
-		HandleError(w, r, err, debug)
+	if err := h(w, r); err != nil {
+		HandleError(w, r, err, Info(r).Debug)
 	}
 }
 
+// HandleError answers r with the status code attached to err, 500 if there is
+// none. The error is rendered as application/problem+json, HTML page or plain
+// text, depending on the Accept header. The message of err is only shown for
+// client errors and in debug mode, which adds the stack of err.
 func HandleError(w http.ResponseWriter, r *http.Request, err error, debug bool) int {
 
+	p := NewProblem(r, err, debug)
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("X-Content-Type-Options", "nosniff")
+	addVary(h, "Accept")
+
+	format := "text/plain"
+	if accept := r.Header.Get("Accept"); accept != "" {
+		format = negotiate(accept, "text/plain", "application/problem+json", "application/json", "text/html")
+	}
+	switch format {
+	case "application/problem+json", "application/json":
+		body, jsonErr := json.Marshal(p)
+		if jsonErr != nil {
+			// an extension member can't be encoded, drop them
+			p.Extensions = nil
+			body, _ = json.Marshal(p)
+		}
+		h.Set("Content-Type", "application/problem+json")
+		w.WriteHeader(p.Status)
+		w.Write(append(body, '\n'))
+
+	case "text/html":
+		fields, _ := p.Extensions["errors"].([]FieldError)
+		h.Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(p.Status)
+		problemPage.Execute(w, struct {
+			*Problem
+			FieldErrors []FieldError
+		}{p, fields})
+
+	default:
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(p.Status)
+		if debug {
+			fmt.Fprintf(w, "%+v\n", err)
+		} else {
+			fmt.Fprintln(w, p.Detail)
+		}
+	}
+	return p.Status
+}
+
+// NewProblem returns the problem details of err as HandleError renders them.
+func NewProblem(r *http.Request, err error, debug bool) *Problem {
+
 	code := errors.Code(err)
-	if code == int(errors.NoCode) {
-		code = 500
+	if code < 400 || code > 599 {
+		// no code attached or none usable as error status
+		code = http.StatusInternalServerError
+	}
+
+	p := &Problem{
+		Title:      http.StatusText(code),
+		Status:     code,
+		Detail:     http.StatusText(code),
+		Instance:   r.RequestURI,
+		RequestID:  Info(r).ID,
+		Extensions: make(map[string]interface{}),
 	}
-	if code == math.MaxUint16 {
-		code = 500
+	if p.Instance == "" {
+		p.Instance = r.URL.RequestURI()
+	}
+	if code < 500 || debug {
+		// messages of server errors may reveal internals like SQL or paths
+		p.Detail = fmt.Sprintf("%v", errors.Cause(err))
 	}
-	w.WriteHeader(code)
 	if debug {
-		http.Error(w, fmt.Sprintf("%+v", err), code)
-	} else {
-		http.Error(w, fmt.Sprintf("%v", errors.Cause(err)), code)
+		p.Stack = fmt.Sprintf("%+v", err)
+	}
+
+	var detailer ProblemDetailer
+	if errors.As(err, &detailer) {
+		detailer.ProblemDetails(p)
 	}
-	return code
+	return p
 }
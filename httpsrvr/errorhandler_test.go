@@ -0,0 +1,56 @@
+package httpsrvr
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ihleven/errors"
+)
+
+func TestHandleErrorDetail(t *testing.T) {
+
+	tests := []struct {
+		err        error
+		debug      bool
+		accept     string
+		want, hide string
+	}{
+		{errors.New("pq: relation users does not exist"), false, "", "Internal Server Error", "pq:"},
+		{errors.New("pq: relation users does not exist"), false, "application/json", `"detail":"Internal Server Error"`, "pq:"},
+		{errors.New("pq: relation users does not exist"), false, "text/html", "<p>Internal Server Error</p>", "pq:"},
+		{errors.New("pq: relation users does not exist"), true, "application/json", "pq: relation users does not exist", ""},
+		{errors.NewWithCode(404, "no user 42"), false, "application/json", `"detail":"no user 42"`, ""},
+		{ValidationError{{"email", "must not be empty"}}, false, "text/plain", "email: must not be empty", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/users", nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		HandleError(w, r, test.err, test.debug)
+		body := w.Body.String()
+		if !strings.Contains(body, test.want) || test.hide != "" && strings.Contains(body, test.hide) {
+			t.Errorf("%v (debug %v, %q): got %s", test.err, test.debug, test.accept, body)
+		}
+	}
+}
+
+func TestHandleErrorNegotiation(t *testing.T) {
+
+	tests := []struct{ accept, want string }{
+		{"", "text/plain"},
+		{"application/json", "application/problem+json"},
+		{"application/problem+json", "application/problem+json"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html"},
+		{"image/png", "text/plain"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		HandleError(w, r, errors.NewWithCode(404, "not found"), false)
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, test.want) {
+			t.Errorf("%q: got %q, want %q", test.accept, ct, test.want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package httpsrvr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+// StdoutExporter writes spans as JSON lines, e.g. for development.
+type StdoutExporter struct {
+	mu     sync.Mutex
+	writer io.Writer
+}
+
+// NewStdoutExporter returns an exporter writing to w, os.Stdout if w is nil.
+func NewStdoutExporter(w io.Writer) *StdoutExporter {
+
+	if w == nil {
+		w = os.Stdout
+	}
+	return &StdoutExporter{writer: w}
+}
+
+func (e *StdoutExporter) Export(ctx context.Context, spans []*Span) error {
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := json.NewEncoder(e.writer)
+	for _, span := range spans {
+		if err := encoder.Encode(span); err != nil {
+			return errors.Wrap(err, "cannot write span %s", span.SpanID)
+		}
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP with
+// JSON encoding.
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+	client   *http.Client
+}
+
+// NewOTLPExporter returns an exporter posting to endpoint, usually
+// "http://localhost:4318/v1/traces". Spans are attributed to service.
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		headers:  make(map[string]string),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Header sets a header sent with every export request, e.g. for authentication.
+func (e *OTLPExporter) Header(key, value string) *OTLPExporter {
+
+	e.headers[key] = value
+	return e
+}
+
+// Client replaces the default HTTP client with a timeout of 10 seconds.
+func (e *OTLPExporter) Client(client *http.Client) *OTLPExporter {
+
+	e.client = client
+	return e
+}
+
+func (e *OTLPExporter) Export(ctx context.Context, spans []*Span) error {
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return errors.Wrap(err, "cannot encode spans")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "cannot create export request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "cannot export spans to %s", e.endpoint)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return errors.New("collector at %s responded %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// The types below mirror the JSON mapping of the OTLP ExportTraceServiceRequest.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	TraceState        string          `json:"traceState,omitempty"`
+	Name              string          `json:"name"`
+	Kind              SpanKind        `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    SpanStatus `json:"code"`
+	Message string     `json:"message,omitempty"`
+}
+
+func (e *OTLPExporter) request(spans []*Span) otlpRequest {
+
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "github.com/ihleven/pkg/httpsrvr"}}
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.TraceID.String(),
+			SpanID:            span.SpanID.String(),
+			TraceState:        span.TraceState,
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: span.Status, Message: span.StatusMessage},
+		}
+		if span.ParentID.IsValid() {
+			s.ParentSpanID = span.ParentID.String()
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+
+	resource := otlpResource{Attributes: otlpAttributes(map[string]string{"service.name": e.service})}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{Resource: resource, ScopeSpans: []otlpScopeSpans{scope}}}}
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := make([]otlpAttribute, len(keys))
+	for i, key := range keys {
+		result[i] = otlpAttribute{Key: key, Value: otlpValue{StringValue: attributes[key]}}
+	}
+	return result
+}
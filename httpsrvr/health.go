@@ -0,0 +1,220 @@
+package httpsrvr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+// HealthCheck reports the health of a component, e.g. by pinging a database.
+// It should return when ctx is done.
+type HealthCheck func(ctx context.Context) error
+
+// Health is a registry of named health checks. Liveness checks decide whether
+// the process works at all, readiness checks whether it should get traffic.
+type Health struct {
+	mu       sync.RWMutex
+	checks   map[string]*healthCheck
+	draining int32
+}
+
+type healthCheck struct {
+	name     string
+	check    HealthCheck
+	timeout  time.Duration
+	critical bool // a failing critical check fails readiness
+	liveness bool
+}
+
+// CheckResult is the outcome of a single check as rendered by the health handlers.
+type CheckResult struct {
+	Status   string `json:"status"` // "ok" or "failing"
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// HealthReport is the JSON body of the health handlers.
+type HealthReport struct {
+	Status string                 `json:"status"` // "ok", "degraded", "failing" or "draining"
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+func newHealth() *Health {
+	return &Health{checks: make(map[string]*healthCheck)}
+}
+
+// Health returns the health check registry of the server.
+func (s *httpServer) Health() *Health {
+	return s.health
+}
+
+// WithHealthEndpoints registers the liveness handler at /healthz and the
+// readiness handler at /readyz.
+func (s *httpServer) WithHealthEndpoints() *httpServer {
+
+	s.Register("/healthz", s.health.LivenessHandler()).Name("healthz")
+	s.Register("/readyz", s.health.ReadinessHandler()).Name("readyz")
+	return s
+}
+
+// SetDrainDelay sets how long the server keeps serving after a shutdown signal
+// with readiness failing, so load balancers stop sending traffic before
+// connections are closed.
+func (s *httpServer) SetDrainDelay(delay time.Duration) *httpServer {
+
+	s.drainDelay = delay
+	return s
+}
+
+// Register adds a readiness check. A failing critical check makes the server
+// unready, a failing non-critical one only degrades it. Checks exceeding
+// timeout fail.
+func (h *Health) Register(name string, timeout time.Duration, critical bool, check HealthCheck) *Health {
+
+	h.mu.Lock()
+	h.checks[name] = &healthCheck{name: name, check: check, timeout: timeout, critical: critical}
+	h.mu.Unlock()
+	return h
+}
+
+// RegisterLiveness adds a liveness check. Failing liveness checks fail /healthz
+// and suspend systemd watchdog pings, so the process gets restarted.
+func (h *Health) RegisterLiveness(name string, timeout time.Duration, check HealthCheck) *Health {
+
+	h.mu.Lock()
+	h.checks[name] = &healthCheck{name: name, check: check, timeout: timeout, critical: true, liveness: true}
+	h.mu.Unlock()
+	return h
+}
+
+// Drain makes readiness fail from now on. It is called on shutdown.
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Live runs the liveness checks.
+func (h *Health) Live(ctx context.Context) HealthReport {
+	return h.run(ctx, true)
+}
+
+// Ready runs the readiness checks.
+func (h *Health) Ready(ctx context.Context) HealthReport {
+
+	if atomic.LoadInt32(&h.draining) != 0 {
+		return HealthReport{Status: "draining"}
+	}
+	return h.run(ctx, false)
+}
+
+// liveness returns an error if a liveness check fails, see httpServer.SetLiveness.
+func (h *Health) liveness() error {
+
+	report := h.Live(context.Background())
+	if report.Status == "failing" {
+		for name, result := range report.Checks {
+			if result.Status != "ok" {
+				return errors.New("%s: %s", name, result.Error)
+			}
+		}
+	}
+	return nil
+}
+
+func (h *Health) run(ctx context.Context, liveness bool) HealthReport {
+
+	h.mu.RLock()
+	var checks []*healthCheck
+	for _, c := range h.checks {
+		if c.liveness == liveness {
+			checks = append(checks, c)
+		}
+	}
+	h.mu.RUnlock()
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *healthCheck) {
+			defer wg.Done()
+			results[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+		if results[i].Status == "ok" {
+			continue
+		}
+		if c.critical {
+			report.Status = "failing"
+		} else if report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+	return report
+}
+
+func (c *healthCheck) run(ctx context.Context) CheckResult {
+
+	start := time.Now()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = errors.Wrap(ctx.Err(), "check did not finish within %v", c.timeout)
+	}
+
+	result := CheckResult{Status: "ok", Critical: c.critical, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status, result.Error = "failing", err.Error()
+	}
+	return result
+}
+
+// LivenessHandler answers with the liveness report, 503 if it is failing.
+func (h *Health) LivenessHandler() http.Handler {
+	return h.handler(h.Live)
+}
+
+// ReadinessHandler answers with the readiness report, 503 if it is failing or
+// the server is draining.
+func (h *Health) ReadinessHandler() http.Handler {
+	return h.handler(h.Ready)
+}
+
+func (h *Health) handler(report func(context.Context) HealthReport) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		result := report(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if result.Status == "failing" || result.Status == "draining" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+}
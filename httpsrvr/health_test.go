@@ -0,0 +1,193 @@
+package httpsrvr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+func TestWatchdogPingsWhileDraining(t *testing.T) {
+
+	var mu sync.Mutex
+	var states []string
+	sdNotify = func(state string) {
+		mu.Lock()
+		states = append(states, state)
+		mu.Unlock()
+	}
+	sdWatchdogInterval = func() time.Duration { return 20 * time.Millisecond }
+	defer func() { sdNotify, sdWatchdogInterval = nil, nil }()
+
+	s := NewServer(0, false).SetDrainDelay(200 * time.Millisecond)
+	s.log = &recordingLogger{}
+	s.server = &http.Server{}
+	supervisorDone, supervisorStopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		// the supervisor must not notify after sdNotify is reset
+		close(supervisorDone)
+		<-supervisorStopped
+	}()
+	go func() {
+		s.superviseSystemd(supervisorDone)
+		close(supervisorStopped)
+	}()
+
+	shutdown := make(chan bool)
+	go s.shutdownWaiter(shutdown)
+	close(s.handover)
+
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	before := len(states)
+	mu.Unlock()
+	time.Sleep(80 * time.Millisecond)
+	mu.Lock()
+	pings := 0
+	for _, state := range states[before:] {
+		if strings.HasPrefix(state, "WATCHDOG=1") {
+			pings++
+		}
+	}
+	mu.Unlock()
+	if pings == 0 {
+		t.Error("no watchdog pings during the drain delay")
+	}
+	<-shutdown
+	time.Sleep(30 * time.Millisecond)
+	if s.healthy() {
+		t.Error("watchdog pings continue after shutdown")
+	}
+}
+
+func healthStatus(t *testing.T, handler http.Handler) (int, HealthReport) {
+
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	var report HealthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if w.Header().Get("Content-Type") != "application/json" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("got headers %v", w.Header())
+	}
+	return w.Code, report
+}
+
+func TestHealthHandlers(t *testing.T) {
+
+	ok := func(context.Context) error { return nil }
+	fail := func(context.Context) error { return errors.New("connection refused") }
+
+	tests := []struct {
+		name            string
+		setup           func(*Health)
+		liveness        int
+		readiness       int
+		readinessStatus string
+	}{
+		{"no checks", func(h *Health) {}, 200, 200, "ok"},
+		{"ok", func(h *Health) { h.Register("db", 0, true, ok).RegisterLiveness("loop", 0, ok) }, 200, 200, "ok"},
+		{"critical failing", func(h *Health) { h.Register("db", 0, true, fail).Register("cache", 0, false, ok) }, 200, 503, "failing"},
+		{"non-critical failing", func(h *Health) { h.Register("db", 0, true, ok).Register("cache", 0, false, fail) }, 200, 200, "degraded"},
+		{"both failing", func(h *Health) { h.Register("db", 0, true, fail).Register("cache", 0, false, fail) }, 200, 503, "failing"},
+		{"liveness failing", func(h *Health) { h.Register("db", 0, true, ok).RegisterLiveness("loop", 0, fail) }, 503, 200, "ok"},
+	}
+	for _, test := range tests {
+		h := newHealth()
+		test.setup(h)
+		if status, _ := healthStatus(t, h.LivenessHandler()); status != test.liveness {
+			t.Errorf("%s: got liveness %d, want %d", test.name, status, test.liveness)
+		}
+		status, report := healthStatus(t, h.ReadinessHandler())
+		if status != test.readiness || report.Status != test.readinessStatus {
+			t.Errorf("%s: got readiness %d %s, want %d %s", test.name, status, report.Status, test.readiness, test.readinessStatus)
+		}
+	}
+}
+
+func TestHealthReport(t *testing.T) {
+
+	h := newHealth()
+	h.Register("db", 0, true, func(context.Context) error { return nil })
+	h.Register("cache", 0, false, func(context.Context) error { return errors.New("connection refused") })
+	h.RegisterLiveness("loop", 0, func(context.Context) error { return nil })
+
+	_, report := healthStatus(t, h.ReadinessHandler())
+	if len(report.Checks) != 2 {
+		t.Fatalf("got checks %v, want db and cache only", report.Checks)
+	}
+	if db := report.Checks["db"]; db.Status != "ok" || !db.Critical || db.Error != "" || db.Duration == "" {
+		t.Errorf("got db %+v", db)
+	}
+	if cache := report.Checks["cache"]; cache.Status != "failing" || cache.Critical || cache.Error != "connection refused" {
+		t.Errorf("got cache %+v", cache)
+	}
+	if _, report := healthStatus(t, h.LivenessHandler()); len(report.Checks) != 1 || report.Checks["loop"].Status != "ok" {
+		t.Errorf("got liveness checks %v, want loop only", report.Checks)
+	}
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+
+	release := make(chan struct{})
+	defer close(release)
+	h := newHealth()
+	// the check ignores its context, the timeout applies nonetheless
+	h.Register("stuck", 20*time.Millisecond, true, func(context.Context) error { <-release; return nil })
+	h.Register("slow", time.Second, false, func(ctx context.Context) error {
+		select {
+		case <-time.After(10 * time.Millisecond):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	start := time.Now()
+	status, report := healthStatus(t, h.ReadinessHandler())
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("checks took %v", elapsed)
+	}
+	if status != 503 || report.Status != "failing" {
+		t.Errorf("got %d %s, want 503 failing", status, report.Status)
+	}
+	if stuck := report.Checks["stuck"]; stuck.Status != "failing" || !strings.Contains(stuck.Error, "within 20ms") {
+		t.Errorf("got stuck %+v", stuck)
+	}
+	if slow := report.Checks["slow"]; slow.Status != "ok" {
+		t.Errorf("got slow %+v", slow)
+	}
+}
+
+func TestReadinessDrain(t *testing.T) {
+
+	s := NewServer(0, false).WithHealthEndpoints()
+	s.logger = discardAccessLog{}
+	s.Health().Register("db", 0, true, func(context.Context) error { return nil })
+
+	get := func(path string) (int, HealthReport) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		var report HealthReport
+		json.Unmarshal(w.Body.Bytes(), &report)
+		return w.Code, report
+	}
+	if status, report := get("/readyz"); status != 200 || report.Status != "ok" {
+		t.Errorf("got readiness %d %s before drain", status, report.Status)
+	}
+	s.Health().Drain()
+	if status, report := get("/readyz"); status != 503 || report.Status != "draining" || report.Checks != nil {
+		t.Errorf("got readiness %d %s %v after drain, want 503 draining", status, report.Status, report.Checks)
+	}
+	if status, report := get("/healthz"); status != 200 || report.Status != "ok" {
+		t.Errorf("got liveness %d %s after drain, want 200 ok", status, report.Status)
+	}
+}
@@ -0,0 +1,99 @@
+package httpsrvr
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Host returns the root dispatcher of the routes served for the host name
+// pattern, creating it if necessary. A pattern like "*.example.org" matches
+// every subdomain of example.org; the most specific pattern wins and exact
+// names take precedence over wildcards. Requests for unknown hosts are
+// served by the default routes registered with s.Register, which Host also
+// returns for the patterns "" and "*".
+func (s *httpServer) Host(pattern string) *dispatcher {
+
+	// IPv6 literals are matched without brackets like hosts without port
+	pattern = strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]"))
+	if pattern == "" || pattern == "*" {
+		return s.routes
+	}
+
+	hosts, key := s.hosts, pattern
+	if strings.HasPrefix(pattern, "*.") {
+		hosts, key = s.wildcards, pattern[1:]
+	}
+	if d, ok := hosts[key]; ok {
+		return d
+	}
+
+	d := NewDispatcher(nil, pattern)
+	d.Use(s.use...)
+	hosts[key] = d
+	return d
+}
+
+// matchHost returns the routes for the host of a request, the matched pattern
+// and the part of the host name matched by the wildcard.
+func (s *httpServer) matchHost(host string) (*dispatcher, string, string) {
+
+	if len(s.hosts) == 0 && len(s.wildcards) == 0 {
+		return s.routes, "", ""
+	}
+	host = strings.ToLower(stripPort(host))
+
+	if d, ok := s.hosts[host]; ok {
+		return d, d.name, ""
+	}
+	for i := 0; i < len(host); i++ {
+		if host[i] != '.' {
+			continue
+		}
+		if d, ok := s.wildcards[host[i:]]; ok && i > 0 {
+			return d, d.name, host[:i]
+		}
+	}
+	return s.routes, "", ""
+}
+
+// hostRoutes returns the root dispatchers of all virtual hosts sorted by pattern.
+func (s *httpServer) hostRoutes() []*dispatcher {
+
+	var hosts []*dispatcher
+	for _, d := range s.hosts {
+		hosts = append(hosts, d)
+	}
+	for _, d := range s.wildcards {
+		hosts = append(hosts, d)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].name < hosts[j].name })
+	return hosts
+}
+
+func stripPort(hostport string) string {
+
+	host := hostport
+	if i := strings.LastIndexByte(hostport, ':'); i >= 0 && strings.LastIndexByte(hostport, ']') < i {
+		host = hostport[:i]
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// VirtualHost returns the host pattern the request was matched against or ""
+// if it is served by the default routes.
+func VirtualHost(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.host
+	}
+	return ""
+}
+
+// Subdomain returns the part of the host name matched by the wildcard of a
+// pattern like "*.example.org", e.g. "blog" for blog.example.org.
+func Subdomain(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.subdomain
+	}
+	return ""
+}
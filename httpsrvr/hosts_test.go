@@ -0,0 +1,90 @@
+package httpsrvr
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchHost(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.Host("example.org")
+	s.Host("Shop.Example.org")
+	s.Host("*.example.org")
+	s.Host("*.blog.example.org")
+	s.Host("[::1]")
+
+	tests := []struct {
+		host, routes, pattern, subdomain string
+	}{
+		{"example.org", "example.org", "example.org", ""},
+		{"EXAMPLE.org:8080", "example.org", "example.org", ""},
+		{"shop.example.org", "shop.example.org", "shop.example.org", ""},
+		{"api.example.org", "*.example.org", "*.example.org", "api"},
+		{"a.b.example.org:443", "*.example.org", "*.example.org", "a.b"},
+		{"jane.blog.example.org", "*.blog.example.org", "*.blog.example.org", "jane"},
+		{"blog.example.org", "*.example.org", "*.example.org", "blog"},
+		{"[::1]:8080", "::1", "::1", ""},
+		{"[::1]", "::1", "::1", ""},
+		{".example.org", "root", "", ""},
+		{"example.com", "root", "", ""},
+		{"", "root", "", ""},
+	}
+	for _, test := range tests {
+		routes, pattern, subdomain := s.matchHost(test.host)
+		if routes.name != test.routes || pattern != test.pattern || subdomain != test.subdomain {
+			t.Errorf("%q: got %s %q %q, want %s %q %q", test.host, routes.name, pattern, subdomain, test.routes, test.pattern, test.subdomain)
+		}
+	}
+
+	if s.Host("*") != s.routes || s.Host("") != s.routes || s.Host("SHOP.example.org") != s.Host("shop.example.org") {
+		t.Error("Host does not return existing routes")
+	}
+}
+
+func TestVirtualHosts(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.logger = discardAccessLog{}
+	reply := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, VirtualHost(r)+" "+Subdomain(r))
+	})
+	s.Register("/", reply)
+	s.Host("*.example.org").Register("/", reply)
+
+	for host, want := range map[string]string{"jane.example.org": "*.example.org jane", "example.com": " "} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Body.String() != want {
+			t.Errorf("%s: got %q, want %q", host, w.Body, want)
+		}
+	}
+}
+
+func TestURLVirtualHost(t *testing.T) {
+
+	s := NewServer(0, false)
+	h := http.NotFoundHandler()
+	s.Register("/about", h)
+	s.Host("blog.example.org").Register("/posts/{slug}", h).Name("post")
+	s.Host("*.example.org").Register("/about/team", h).Name("about")
+
+	tests := []struct {
+		name  string
+		pairs []interface{}
+		want  string
+	}{
+		{"post", []interface{}{"slug", "hello"}, "/posts/hello"},
+		// the default routes come first
+		{"about", nil, "/about"},
+	}
+	for _, test := range tests {
+		if got, err := s.URL(test.name, test.pairs...); err != nil || got != test.want {
+			t.Errorf("%s: got %q, %v, want %q", test.name, got, err, test.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package httpsrvr
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	infoKey contextKey = iota + 1
+	spanKey
+	nonceKey
+)
+
+// requestInfo holds everything the server knows about a request. It is stored
+// in the request context as a single value. Each request gets a new one since
+// the context may outlive the request, e.g. in goroutines started by handlers.
+type requestInfo struct {
+	id        string
+	counter   uint64
+	start     time.Time
+	debug     bool
+	https     bool // via TLS, directly or through a trusted proxy
+	name      string
+	route     string // pattern of the matched dispatcher
+	params    PathParams
+	host      string // matched virtual host pattern
+	subdomain string
+	mu        sync.Mutex // guards user, which is set while the request is served
+	user      string
+}
+
+func (info *requestInfo) setUser(user string) {
+	info.mu.Lock()
+	info.user = user
+	info.mu.Unlock()
+}
+
+func (info *requestInfo) username() string {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	return info.user
+}
+
+// paramsPool recycles the slices path parameters are collected in while
+// matching. The result is copied, so the slices never reach a context.
+var paramsPool = sync.Pool{
+	New: func() interface{} {
+		params := make(PathParams, 0, 8)
+		return &params
+	},
+}
+
+// requestInfoFrom returns the request info stored in ctx by the server or nil.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(infoKey).(*requestInfo)
+	return info
+}
+
+// RequestInfo is what the server knows about a request. It is a copy, so it
+// stays valid after the request.
+type RequestInfo struct {
+	ID        string    // X-Request-ID header or generated from instance and counter
+	Counter   uint64    // number of the request since server start
+	Start     time.Time // time the server received the request
+	Name      string    // name of the matched dispatcher
+	Route     string    // pattern of the matched dispatcher, e.g. "/users/{id}"
+	Debug     bool
+	HTTPS     bool   // connected via TLS, directly or through a trusted proxy
+	User      string // set by authentication middleware via SetUser
+	Host      string // matched virtual host pattern
+	Subdomain string
+}
+
+// Info returns the info of r. Outside the server, e.g. in tests or when
+// handlers are mounted in a foreign server, it is the zero value unless set
+// with WithInfo.
+func Info(r *http.Request) RequestInfo {
+	info, _ := InfoFromContext(r.Context())
+	return info
+}
+
+// InfoFromContext returns the request info stored in ctx and whether there is
+// any.
+func InfoFromContext(ctx context.Context) (RequestInfo, bool) {
+
+	info := requestInfoFrom(ctx)
+	if info == nil {
+		return RequestInfo{}, false
+	}
+	return RequestInfo{
+		ID:        info.id,
+		Counter:   info.counter,
+		Start:     info.start,
+		Name:      info.name,
+		Route:     info.route,
+		Debug:     info.debug,
+		HTTPS:     info.https,
+		User:      info.username(),
+		Host:      info.host,
+		Subdomain: info.subdomain,
+	}, true
+}
+
+// WithInfo returns a copy of ctx carrying info, e.g. to test handlers relying
+// on the request id, path parameters or debug mode without running the server.
+func WithInfo(ctx context.Context, info RequestInfo, params ...PathParam) context.Context {
+
+	return context.WithValue(ctx, infoKey, &requestInfo{
+		id:        info.ID,
+		counter:   info.Counter,
+		start:     info.Start,
+		debug:     info.Debug,
+		https:     info.HTTPS,
+		name:      info.Name,
+		route:     info.Route,
+		params:    PathParams(params),
+		host:      info.Host,
+		subdomain: info.Subdomain,
+		user:      info.User,
+	})
+}
+
+// SetUser records the authenticated user of r for the access log and ByUser
+// rate limiting. It is called by authentication middleware.
+func SetUser(r *http.Request, user string) {
+
+	if info := requestInfoFrom(r.Context()); info != nil {
+		info.setUser(user)
+	}
+}
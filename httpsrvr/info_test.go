@@ -0,0 +1,152 @@
+package httpsrvr
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+func TestInfoOutlivesRequest(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.logger = discardAccessLog{}
+	contexts := make(map[string]context.Context)
+	s.Register("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		SetUser(r, "user"+Param(r, "id"))
+		contexts[Param(r, "id")] = r.Context()
+	})
+
+	for _, id := range []string{"1", "2", "3"} {
+		r := httptest.NewRequest("GET", "/users/"+id, nil)
+		r.Header.Set("X-Request-ID", "req"+id)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// contexts kept beyond the request still see their own request
+	for id, ctx := range contexts {
+		info, ok := InfoFromContext(ctx)
+		if !ok || info.ID != "req"+id || info.User != "user"+id || info.Route != "/users/{id}" {
+			t.Errorf("%s: got %+v", id, info)
+		}
+		if got := ParamsFromContext(ctx).Get("id"); got != id {
+			t.Errorf("%s: got param %q", id, got)
+		}
+	}
+}
+
+func TestSetUserConcurrently(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.logger = discardAccessLog{}
+	done := make(chan struct{})
+	s.Register("/", func(w http.ResponseWriter, r *http.Request) {
+		go func(ctx context.Context) {
+			InfoFromContext(ctx)
+			close(done)
+		}(r.Context())
+		SetUser(r, "jane")
+	})
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-done
+}
+
+func TestWithInfo(t *testing.T) {
+
+	r := httptest.NewRequest("GET", "/users/42", nil)
+	if info, ok := InfoFromContext(r.Context()); ok || info != (RequestInfo{}) || Info(r) != (RequestInfo{}) {
+		t.Errorf("got %+v outside the server", info)
+	}
+	SetUser(r, "jane") // no info to record it in, must not panic
+	if Param(r, "id") != "" {
+		t.Error("got param outside the server")
+	}
+
+	want := RequestInfo{ID: "req1", Counter: 7, Start: time.Now(), Name: "users", Route: "/users/{id}", Debug: true, HTTPS: true}
+	r = r.WithContext(WithInfo(r.Context(), want, PathParam{"id", "42"}))
+	if got := Info(r); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if id, err := ParamInt(r, "id"); err != nil || id != 42 {
+		t.Errorf("got param %d, %v", id, err)
+	}
+	SetUser(r, "jane")
+	if got := Info(r).User; got != "jane" {
+		t.Errorf("got user %q", got)
+	}
+}
+
+func TestErrorHandlerOutsideServer(t *testing.T) {
+
+	tests := []struct {
+		ctx    func(context.Context) context.Context
+		err    error
+		status int
+		body   string
+	}{
+		{nil, errors.NewWithCode(404, "no user 42"), 404, "no user 42"},
+		{nil, errors.New("db down"), 500, "Internal Server Error"},
+		{func(ctx context.Context) context.Context {
+			return WithInfo(ctx, RequestInfo{Debug: true})
+		}, errors.New("db down"), 500, "db down"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if test.ctx != nil {
+			r = r.WithContext(test.ctx(r.Context()))
+		}
+		w := httptest.NewRecorder()
+		err := test.err
+		ErrorHandler(func(w http.ResponseWriter, r *http.Request) error { return err }).ServeHTTP(w, r)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%v: got %d %q, want %d %q", test.err, w.Code, w.Body, test.status, test.body)
+		}
+	}
+}
+
+func TestSetUserAccessLogAndRateLimit(t *testing.T) {
+
+	s := NewServer(0, false)
+	recorder := &entryRecorder{}
+	s.logger = recorder
+	authenticate := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user := r.Header.Get("X-User"); user != "" {
+				SetUser(r, user)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	s.Register("/api", func(w http.ResponseWriter, r *http.Request) {}).
+		Use(authenticate, NewRateLimit(0.001, 1).By(ByUser).Middleware)
+
+	tests := []struct {
+		user, remote string
+		status       int
+		logged       string
+	}{
+		{"jane", "192.0.2.1:1", 200, "jane"},
+		{"jane", "192.0.2.2:1", 429, "jane"}, // same user from another IP
+		{"joe", "192.0.2.1:1", 200, "joe"},
+		{"", "192.0.2.1:1", 200, "-"}, // anonymous, limited by IP
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/api", nil)
+		r.RemoteAddr = test.remote
+		if test.user != "" {
+			r.Header.Set("X-User", test.user)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%d %q: got %d, want %d", i, test.user, w.Code, test.status)
+		}
+		if got := recorder.entries[i].Username; got != test.logged {
+			t.Errorf("%d %q: logged user %q, want %q", i, test.user, got, test.logged)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package httpsrvr
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ihleven/errors"
+)
+
+// listener is a socket the server accepts connections on.
+type listener struct {
+	network string // "tcp", "unix" or "systemd"
+	addr    string
+	mode    os.FileMode // permissions of unix sockets, 0 to keep the default
+	uid     int         // owner of unix sockets, -1 to keep
+	gid     int
+	tls     *tls.Config
+	ln      net.Listener
+}
+
+// Listen adds a TCP listener on addr, e.g. ":8080". With a non-nil tlsConfig it
+// serves HTTPS; TLSConfig returns the one using the certificates of WithTLS.
+// Without Listen calls Run listens on the address given to NewServer.
+func (s *httpServer) Listen(addr string, tlsConfig *tls.Config) *httpServer {
+
+	s.listeners = append(s.listeners, &listener{network: "tcp", addr: addr, uid: -1, gid: -1, tls: tlsConfig})
+	return s
+}
+
+// ListenUnix adds a Unix domain socket listener at path. A stale socket file is
+// removed first. mode, uid and gid set permissions and ownership of the socket
+// file; pass 0 and -1 to keep the defaults.
+func (s *httpServer) ListenUnix(path string, mode os.FileMode, uid, gid int, tlsConfig *tls.Config) *httpServer {
+
+	s.listeners = append(s.listeners, &listener{network: "unix", addr: path, mode: mode, uid: uid, gid: gid, tls: tlsConfig})
+	return s
+}
+
+// AddListener adds an already bound listener.
+func (s *httpServer) AddListener(ln net.Listener, tlsConfig *tls.Config) *httpServer {
+
+	s.listeners = append(s.listeners, &listener{network: ln.Addr().Network(), addr: ln.Addr().String(), uid: -1, gid: -1, tls: tlsConfig, ln: ln})
+	return s
+}
+
+// TLSConfig returns the TLS configuration using the certificates given to
+// WithTLS or nil if there are none.
+func (s *httpServer) TLSConfig() *tls.Config {
+	return s.tlsConfig()
+}
+
+// bind opens the socket of l unless it is already open.
+func (l *listener) bind() error {
+
+	if l.ln != nil {
+		return nil
+	}
+
+	if l.network == "unix" {
+		if info, err := os.Stat(l.addr); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(l.addr)
+		}
+	}
+
+	ln, err := net.Listen(l.network, l.addr)
+	if err != nil {
+		return errors.Wrap(err, "cannot listen on %s %s", l.network, l.addr)
+	}
+
+	if l.network == "unix" {
+		if l.mode != 0 {
+			if err := os.Chmod(l.addr, l.mode); err != nil {
+				ln.Close()
+				return errors.Wrap(err, "cannot set mode of %s", l.addr)
+			}
+		}
+		if l.uid != -1 || l.gid != -1 {
+			if err := os.Chown(l.addr, l.uid, l.gid); err != nil {
+				ln.Close()
+				return errors.Wrap(err, "cannot set owner of %s", l.addr)
+			}
+		}
+	}
+	l.ln = ln
+	return nil
+}
+
+func (l *listener) String() string {
+	if l.tls != nil {
+		return l.network + " " + l.addr + " (tls)"
+	}
+	return l.network + " " + l.addr
+}
+
+// bindListeners returns all listeners of the server bound and ready to serve:
+// the ones added explicitly, those activated by systemd in systemd mode and,
+// if neither exist, a TCP listener on the address given to NewServer.
+// Listeners handed over by a previous process are used instead of binding anew.
+func (s *httpServer) bindListeners() ([]*listener, error) {
+
+	inherited, err := inheritedListeners()
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := append([]*listener(nil), s.listeners...)
+
+	if s.systemd {
+		activated, err := s.systemdListeners(inherited)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, activated...)
+	}
+
+	if len(listeners) == 0 {
+		listeners = append(listeners, &listener{network: "tcp", addr: s.addr, uid: -1, gid: -1, tls: s.tlsConfig()})
+	}
+
+	for _, l := range listeners {
+		if ln, ok := inherited[l.key()]; ok && l.ln == nil {
+			l.ln = ln
+			delete(inherited, l.key())
+		}
+	}
+	for key, ln := range inherited {
+		s.log.Info("Closing unused inherited listener %s", key)
+		ln.Close()
+	}
+
+	for i, l := range listeners {
+		if err := l.bind(); err != nil {
+			for _, bound := range listeners[:i] {
+				bound.ln.Close()
+			}
+			return nil, err
+		}
+	}
+	return listeners, nil
+}
+
+// serve serves on all listeners until the server is shut down and returns the
+// first error other than http.ErrServerClosed.
+func (s *httpServer) serve(listeners []*listener) error {
+
+	errs := make(chan error, len(listeners))
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+			ln := l.ln
+			if l.tls != nil {
+				ln = tls.NewListener(ln, l.tls)
+			}
+			s.log.Info("+++ Serving on %v +++", l)
+			if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				errs <- errors.Wrap(err, "cannot serve on %v", l)
+				// a broken listener takes the others down
+				s.server.Close()
+			}
+		}(l)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
@@ -0,0 +1,128 @@
+package httpsrvr
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func get(t *testing.T, client *http.Client, url string) string {
+
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestListeners(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets")
+	}
+	socket := filepath.Join(t.TempDir(), "srv.sock")
+
+	// a stale socket file left behind by a crashed process
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	added, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(0, false).
+		Listen("127.0.0.1:0", nil).
+		ListenUnix(socket, 0600, -1, -1, nil).
+		AddListener(added, nil)
+	s.Register("/", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "hello") })
+
+	listeners, err := s.bindListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 3 {
+		t.Fatalf("got %d listeners, want 3", len(listeners))
+	}
+	if listeners[2].ln != added {
+		t.Error("added listener bound anew")
+	}
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSocket == 0 || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode %v, want 0600", info.Mode())
+	}
+
+	s.server = &http.Server{Handler: s}
+	served := make(chan error)
+	go func() { served <- s.serve(listeners) }()
+
+	for _, l := range []*listener{listeners[0], listeners[2]} {
+		if body := get(t, http.DefaultClient, "http://"+l.ln.Addr().String()+"/"); body != "hello" {
+			t.Errorf("%v: got %q", l, body)
+		}
+	}
+	if body := get(t, unixClient(socket), "http://unix/"); body != "hello" {
+		t.Errorf("%v: got %q", listeners[1], body)
+	}
+
+	s.server.Shutdown(context.Background())
+	if err := <-served; err != nil {
+		t.Errorf("serve returned %v", err)
+	}
+}
+
+func TestListenersDefault(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.addr = "127.0.0.1:0"
+	listeners, err := s.bindListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listeners[0].ln.Close()
+	if len(listeners) != 1 || listeners[0].network != "tcp" {
+		t.Errorf("got %v, want the address given to NewServer", listeners)
+	}
+}
+
+func TestListenersBindError(t *testing.T) {
+
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	s := NewServer(0, false).Listen("127.0.0.1:0", nil).Listen(taken.Addr().String(), nil)
+	if _, err := s.bindListeners(); err == nil {
+		t.Fatal("no error binding an address in use")
+	}
+	// the listener bound before the failing one is closed again
+	if _, err := s.listeners[0].ln.Accept(); err == nil {
+		t.Error("first listener still open")
+	}
+}
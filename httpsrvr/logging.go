@@ -1,6 +1,10 @@
 package httpsrvr
 
-import "time"
+import (
+	"time"
+
+	"github.com/ihleven/pkg/log"
+)
 
 type logger interface {
 	Debug(format string, args ...interface{})
@@ -11,4 +15,10 @@ type accesslogger interface {
 	Access(reqNum uint64, reqID string, start time.Time, addr, user, method, uri, proto string, status, size int, duration time.Duration, referer, agent string)
 }
 
+// entryLogger is implemented by access loggers logging the full entry
+// including the host, like log.AccessLogger.
+type entryLogger interface {
+	Log(e log.AccessEntry)
+}
+
 // https://pmihaylov.com/go-structured-logs/
@@ -0,0 +1,356 @@
+package httpsrvr
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{100, 1000, 10000, 100000, 1e6, 1e7}
+)
+
+// Metrics collects request metrics of a server and renders them in the
+// Prometheus text exposition format.
+type Metrics struct {
+	mu       sync.Mutex
+	requests map[requestLabels]*requestMetrics
+	counters map[string]*Counter
+	gauges   map[string]*gauge
+	started  time.Time
+}
+
+type requestLabels struct {
+	route  string // dispatcher name
+	method string
+	class  string // status class like "2xx"
+}
+
+type requestMetrics struct {
+	count    uint64
+	duration histogram
+	size     histogram
+}
+
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // cumulative is computed on exposition
+	sum     float64
+	count   uint64
+}
+
+// Counter is a monotonically increasing metric registered via Metrics.Counter.
+type Counter struct {
+	help  string
+	value uint64
+}
+
+type gauge struct {
+	help  string
+	value func() float64
+}
+
+func newMetrics() *Metrics {
+	return &Metrics{
+		requests: make(map[requestLabels]*requestMetrics),
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*gauge),
+		started:  time.Now(),
+	}
+}
+
+// Metrics returns the metrics of the server.
+func (s *httpServer) Metrics() *Metrics {
+	return s.metrics
+}
+
+// WithMetricsEndpoint registers the Prometheus metrics handler at /metrics.
+func (s *httpServer) WithMetricsEndpoint() *httpServer {
+
+	s.Register("/metrics", s.metrics).Name("metrics")
+	return s
+}
+
+// Counter returns the counter called name, creating it if necessary. The name
+// may carry labels, e.g. `requests_total{code="200"}`, see Labels.
+func (m *Metrics) Counter(name, help string) *Counter {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.counters[name]
+	if !ok {
+		c = &Counter{help: help}
+		m.counters[name] = c
+	}
+	return c
+}
+
+// Gauge registers a gauge called name whose value is read from fn on exposition.
+func (m *Metrics) Gauge(name, help string, fn func() float64) {
+
+	m.mu.Lock()
+	m.gauges[name] = &gauge{help: help, value: fn}
+	m.mu.Unlock()
+}
+
+// Inc increments the counter by one.
+func (c *Counter) Inc() {
+	atomic.AddUint64(&c.value, 1)
+}
+
+// Add increments the counter by delta.
+func (c *Counter) Add(delta uint64) {
+	atomic.AddUint64(&c.value, delta)
+}
+
+// Value returns the current count.
+func (c *Counter) Value() uint64 {
+	return atomic.LoadUint64(&c.value)
+}
+
+// observe records a finished request.
+func (m *Metrics) observe(route, method string, status, size int, duration time.Duration) {
+
+	labels := requestLabels{route: route, method: metricMethod(method), class: strconv.Itoa(status/100) + "xx"}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rm, ok := m.requests[labels]
+	if !ok {
+		rm = &requestMetrics{duration: newHistogram(durationBuckets), size: newHistogram(sizeBuckets)}
+		m.requests[labels] = rm
+	}
+	rm.count++
+	rm.duration.observe(duration.Seconds())
+	rm.size.observe(float64(size))
+}
+
+// metricMethod bounds the cardinality of the method label.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func newHistogram(buckets []float64) histogram {
+	return histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// ServeHTTP renders the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format to w. The lock is
+// only held to take a snapshot, gauge functions are called without it.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+
+	m.mu.Lock()
+	requests := make(map[requestLabels]requestMetrics, len(m.requests))
+	labels := make([]requestLabels, 0, len(m.requests))
+	for l, rm := range m.requests {
+		requests[l] = requestMetrics{count: rm.count, duration: rm.duration.copy(), size: rm.size.copy()}
+		labels = append(labels, l)
+	}
+	counters := make(map[string]*Counter, len(m.counters))
+	for name, c := range m.counters {
+		counters[name] = c
+	}
+	gauges := make(map[string]*gauge, len(m.gauges))
+	for name, g := range m.gauges {
+		gauges[name] = g
+	}
+	m.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	sort.Slice(labels, func(i, j int) bool {
+		a, b := labels[i], labels[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.class < b.class
+	})
+
+	header(bw, "httpsrvr_requests_total", "counter", "Total number of HTTP requests.")
+	for _, l := range labels {
+		fmt.Fprintf(bw, "httpsrvr_requests_total{%s} %d\n", l, requests[l].count)
+	}
+	header(bw, "httpsrvr_request_duration_seconds", "histogram", "Duration of HTTP requests in seconds.")
+	for _, l := range labels {
+		rm := requests[l]
+		rm.duration.write(bw, "httpsrvr_request_duration_seconds", l.String())
+	}
+	header(bw, "httpsrvr_response_size_bytes", "histogram", "Size of HTTP response bodies in bytes.")
+	for _, l := range labels {
+		rm := requests[l]
+		rm.size.write(bw, "httpsrvr_response_size_bytes", l.String())
+	}
+
+	helps := make(map[string]string, len(counters))
+	for name, c := range counters {
+		helps[name] = c.help
+	}
+	writeFamilies(bw, "counter", helps, func(name string) string {
+		return strconv.FormatUint(counters[name].Value(), 10)
+	})
+	helps = make(map[string]string, len(gauges))
+	for name, g := range gauges {
+		helps[name] = g.help
+	}
+	writeFamilies(bw, "gauge", helps, func(name string) string {
+		return formatFloat(gauges[name].value())
+	})
+
+	writeRuntimeMetrics(bw, m.started)
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// writeFamilies writes series grouped by their metric family, each family
+// with a single header. Sorting the series names alone would not do since
+// '_' sorts before '{', e.g. foo, foo_bar, foo{x="1"}.
+func writeFamilies(w *bufio.Writer, typ string, helps map[string]string, value func(name string) string) {
+
+	families := make(map[string][]string)
+	for name := range helps {
+		f := family(name)
+		families[f] = append(families[f], name)
+	}
+
+	names := make([]string, 0, len(families))
+	for f := range families {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+	for _, f := range names {
+		sort.Strings(families[f])
+		header(w, f, typ, helps[families[f][0]])
+		for _, name := range families[f] {
+			fmt.Fprintf(w, "%s %s\n", name, value(name))
+		}
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+func (l requestLabels) String() string {
+	return fmt.Sprintf(`route="%s",method="%s",status="%s"`, escapeLabel(l.route), l.method, l.class)
+}
+
+func (h histogram) copy() histogram {
+	h.counts = append([]uint64(nil), h.counts...)
+	return h
+}
+
+func (h *histogram) write(w *bufio.Writer, name, labels string) {
+
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func writeRuntimeMetrics(w *bufio.Writer, started time.Time) {
+
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	header(w, "go_info", "gauge", "Information about the Go environment.")
+	fmt.Fprintf(w, "go_info{version=\"%s\"} 1\n", runtime.Version())
+	header(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+	header(w, "go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	fmt.Fprintf(w, "go_memstats_alloc_bytes %d\n", ms.Alloc)
+	header(w, "go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.")
+	fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", ms.Sys)
+	header(w, "go_memstats_heap_objects", "gauge", "Number of allocated objects.")
+	fmt.Fprintf(w, "go_memstats_heap_objects %d\n", ms.HeapObjects)
+	header(w, "go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", ms.NumGC)
+	header(w, "go_gc_pause_seconds_total", "counter", "Total GC pause time in seconds.")
+	fmt.Fprintf(w, "go_gc_pause_seconds_total %s\n", formatFloat(float64(ms.PauseTotalNs)/1e9))
+	header(w, "process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.")
+	fmt.Fprintf(w, "process_start_time_seconds %d\n", started.Unix())
+}
+
+// Labels formats label pairs for appending to a series name:
+//
+//	m.Counter("jobs_total"+httpsrvr.Labels("queue", name), "Number of jobs.")
+func Labels(pairs ...string) string {
+
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i] + `="` + escapeLabel(pairs[i+1]) + `"`)
+	}
+	return "{" + b.String() + "}"
+}
+
+// family returns the metric name of a series name without labels.
+func family(name string) string {
+	if i := strings.IndexByte(name, '{'); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+func header(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, typ)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
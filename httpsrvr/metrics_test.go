@@ -0,0 +1,77 @@
+package httpsrvr
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsGaugeCallbackRegisters(t *testing.T) {
+
+	m := newMetrics()
+	m.Gauge("jobs", "Number of jobs.", func() float64 {
+		// registering metrics from a gauge must not deadlock the exposition
+		m.Counter("jobs_read_total", "Number of reads of the jobs gauge.").Inc()
+		return 1
+	})
+
+	done := make(chan string)
+	go func() {
+		var b strings.Builder
+		var _ io.WriterTo = m
+		m.WriteTo(&b)
+		done <- b.String()
+	}()
+	select {
+	case out := <-done:
+		if !strings.Contains(out, "jobs 1\n") {
+			t.Errorf("gauge missing in output:\n%s", out)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("WriteTo deadlocked")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+
+	m := newMetrics()
+	m.observe("users", "GET", 200, 512, 30*time.Millisecond)
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	out := w.Body.String()
+	for _, want := range []string{
+		`httpsrvr_requests_total{route="users",method="GET",status="2xx"} 1`,
+		`httpsrvr_request_duration_seconds_bucket{route="users",method="GET",status="2xx",le="0.05"} 1`,
+		`httpsrvr_response_size_bytes_sum{route="users",method="GET",status="2xx"} 512`,
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s", want)
+		}
+	}
+}
+
+func TestMetricsFamilyHeaders(t *testing.T) {
+
+	m := newMetrics()
+	m.Counter("foo", "Foo.").Inc()
+	m.Counter("foo_bar", "Foo bar.").Inc()
+	m.Counter("foo"+Labels("x", "1"), "Foo.").Inc()
+	m.Gauge("baz"+Labels("x", "1"), "Baz.", func() float64 { return 1 })
+	m.Gauge("baz_qux", "Baz qux.", func() float64 { return 2 })
+	m.Gauge("baz"+Labels("x", "2"), "Baz.", func() float64 { return 3 })
+
+	var b strings.Builder
+	m.WriteTo(&b)
+	out := b.String()
+	for _, name := range []string{"foo", "foo_bar", "baz", "baz_qux"} {
+		if n := strings.Count(out, "# TYPE "+name+" "); n != 1 {
+			t.Errorf("%d TYPE lines for %s", n, name)
+		}
+	}
+	if !strings.Contains(out, "# TYPE foo counter\nfoo 1\nfoo{x=\"1\"} 1\n# HELP foo_bar") {
+		t.Errorf("series of foo not grouped:\n%s", out)
+	}
+}
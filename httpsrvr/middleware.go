@@ -6,11 +6,55 @@ import (
 	"golang.org/x/time/rate"
 )
 
-func limit(next http.Handler, limiter *rate.Limiter) http.Handler {
+// Middleware wraps a handler with additional behaviour like authentication.
+type Middleware func(http.Handler) http.Handler
+
+// Use appends middleware to the dispatcher. It applies to the dispatcher and
+// every route registered beneath it, no matter if registered before or after Use.
+// Middleware of parent dispatchers wraps that of their children, and middleware
+// of one dispatcher wraps in the order given, i.e. the first one runs first.
+func (r *dispatcher) Use(middleware ...Middleware) *dispatcher {
+
+	r.use = append(r.use, middleware...)
+	r.tree.compile()
+	return r
+}
+
+// Isolate opts the dispatcher and its subtree out of the middleware of its parents.
+// Middleware added to the dispatcher itself with Use still applies.
+// Note that all parent middleware is dropped, including server-wide middleware
+// added with s.Use like security headers, CSRF protection or compression.
+// Middleware that should still apply has to be added again:
+//
+//	s.Register("/webhooks", h).Isolate(true).Use(securityHeaders.Middleware)
+func (r *dispatcher) Isolate(isolated bool) *dispatcher {
+
+	r.isolated = isolated
+	r.tree.compile()
+	return r
+}
+
+// chain wraps handler with the middleware collected from d up to the root
+// or the first isolated dispatcher.
+func (d *dispatcher) chain(handler http.Handler) http.Handler {
+
+	for n := d; n != nil; n = n.parent {
+		for i := len(n.use) - 1; i >= 0; i-- {
+			handler = n.use[i](handler)
+		}
+		if n.isolated {
+			break
+		}
+	}
+	return handler
+}
+
+func limit(next http.Handler, limiter *rate.Limiter, rejected *Counter) http.Handler {
 	if limiter != nil {
 
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if limiter.Allow() == false {
+				rejected.Inc()
 				http.Error(w, http.StatusText(429), http.StatusTooManyRequests)
 				return
 			}
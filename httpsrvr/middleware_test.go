@@ -0,0 +1,88 @@
+package httpsrvr
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// trace returns middleware appending name to the X-Trace response header.
+func trace(name string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Trace", name)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestUse(t *testing.T) {
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.URL.Path) })
+	root := NewDispatcher(nil, "root")
+	root.Register("/other", h)
+	admin := root.Register("/admin", h).Use(trace("admin1"), trace("admin2"))
+	users := admin.Register("/users", h).Use(trace("users"))
+	admin.Register("/users/{id}/photos", h)
+	public := admin.Register("/public", h).Use(trace("public")).Isolate(true)
+	public.Register("/assets", h)
+	settings := admin.Register("/settings", h).Isolate(true)
+	settings.Isolate(false)
+
+	// added after the routes beneath were registered
+	root.Use(trace("root"))
+	users.Use(trace("users late"))
+
+	tests := []struct {
+		path, trace string
+	}{
+		{"/other", "root"},
+		{"/admin", "root admin1 admin2"},
+		{"/admin/users", "root admin1 admin2 users users late"},
+		{"/admin/users/42/photos", "root admin1 admin2 users users late"},
+		{"/admin/public", "public"},
+		{"/admin/public/assets", "public"},
+		{"/admin/settings", "root admin1 admin2"},
+		{"/unknown", "root"},
+	}
+	for _, test := range tests {
+		d, _, _ := root.GetDispatcher(test.path)
+		w := httptest.NewRecorder()
+		d.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if got := strings.Join(w.Header().Values("X-Trace"), " "); got != test.trace {
+			t.Errorf("%s: got %q, want %q", test.path, got, test.trace)
+		}
+	}
+}
+
+func TestServerUse(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.logger = discardAccessLog{}
+	blog := s.Host("blog.example.org")
+	blog.Register("/posts", http.NotFoundHandler())
+	s.Register("/api", http.NotFoundHandler())
+	s.Use(trace("server"))
+	s.Host("shop.example.org").Register("/cart", http.NotFoundHandler())
+	s.Register("/hooks", http.NotFoundHandler()).Isolate(true)
+
+	tests := []struct {
+		host, path, trace string
+	}{
+		{"example.org", "/api", "server"},
+		{"blog.example.org", "/posts", "server"},
+		{"shop.example.org", "/cart", "server"},
+		{"example.org", "/hooks", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		r.Host = test.host
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if got := strings.Join(w.Header().Values("X-Trace"), " "); got != test.trace {
+			t.Errorf("%s%s: got %q, want %q", test.host, test.path, got, test.trace)
+		}
+	}
+}
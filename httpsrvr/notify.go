@@ -0,0 +1,160 @@
+package httpsrvr
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+// only available on linux, see systemd.go
+var (
+	activatedListeners func() (map[string][]net.Listener, error)
+	sdNotify           func(state string)
+	sdWatchdogInterval func() time.Duration
+)
+
+// statusInterval is how often STATUS= notifications are sent to systemd.
+var statusInterval = 10 * time.Second
+
+// ListenSystemd maps the systemd socket with the given FileDescriptorName to a
+// listener serving TLS with tlsConfig, nil for plain HTTP. Activated sockets
+// without mapping serve TLS if certificates are given via WithTLS. It implies
+// WithSystemd(true).
+func (s *httpServer) ListenSystemd(name string, tlsConfig *tls.Config) *httpServer {
+
+	if s.socketTLS == nil {
+		s.socketTLS = make(map[string]*tls.Config)
+	}
+	s.socketTLS[name] = tlsConfig
+	s.systemd = true
+	return s
+}
+
+// SetLiveness installs a check deciding whether the process is healthy. As
+// long as it or a liveness check of the Health registry fails, no watchdog
+// pings are sent to systemd, which restarts the service after WatchdogSec.
+func (s *httpServer) SetLiveness(check func() error) *httpServer {
+
+	s.liveness = check
+	return s
+}
+
+// systemdListeners returns the listeners activated by systemd with their TLS
+// configuration. After an upgrade they are taken from the inherited listeners.
+func (s *httpServer) systemdListeners(inherited map[string]net.Listener) ([]*listener, error) {
+
+	activated := make(map[string][]net.Listener)
+	for key, ln := range inherited {
+		// key is "systemd <name> <address>"
+		if parts := strings.SplitN(key, " ", 3); len(parts) == 3 && parts[0] == "systemd" {
+			activated[parts[1]] = append(activated[parts[1]], ln)
+			delete(inherited, key)
+		}
+	}
+	if len(activated) == 0 {
+		if activatedListeners == nil {
+			return nil, errors.New("systemd socket activation is only available on linux")
+		}
+		var err error
+		if activated, err = activatedListeners(); err != nil {
+			return nil, err
+		}
+	}
+	if len(activated) == 0 {
+		return nil, errors.New("cannot retrieve systemd listeners")
+	}
+	for name := range s.socketTLS {
+		if len(activated[name]) == 0 {
+			return nil, errors.New("no systemd socket named %q", name)
+		}
+	}
+
+	names := make([]string, 0, len(activated))
+	for name := range activated {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var listeners []*listener
+	for _, name := range names {
+		tlsConfig, ok := s.socketTLS[name]
+		if !ok {
+			tlsConfig = s.tlsConfig()
+		}
+		for _, ln := range activated[name] {
+			listeners = append(listeners, &listener{network: "systemd", addr: name + " " + ln.Addr().String(), uid: -1, gid: -1, tls: tlsConfig, ln: ln})
+		}
+	}
+	return listeners, nil
+}
+
+// notify sends state to systemd if the service runs with a notify socket.
+func (s *httpServer) notify(state string) {
+	if sdNotify != nil {
+		sdNotify(state)
+	}
+}
+
+// healthy reports whether watchdog pings should be sent: until the server has
+// shut down, including the drain delay, unless liveness fails.
+func (s *httpServer) healthy() bool {
+
+	if atomic.LoadInt32(&s.stopping) != 0 {
+		return false
+	}
+	for _, check := range []func() error{s.liveness, s.health.liveness} {
+		if check == nil {
+			continue
+		}
+		if err := check(); err != nil {
+			s.log.Info("Liveness check failed, suspending watchdog: %v", err)
+			return false
+		}
+	}
+	return true
+}
+
+// superviseSystemd sends watchdog pings and status updates until done is closed.
+func (s *httpServer) superviseSystemd(done <-chan struct{}) {
+
+	if sdNotify == nil {
+		return
+	}
+
+	var watchdog <-chan time.Time
+	if sdWatchdogInterval != nil {
+		if interval := sdWatchdogInterval(); interval > 0 {
+			// ping twice per interval as recommended by sd_watchdog_enabled(3)
+			ticker := time.NewTicker(interval / 2)
+			defer ticker.Stop()
+			watchdog = ticker.C
+			s.log.Debug("Sending systemd watchdog pings every %v", interval/2)
+		}
+	}
+	status := time.NewTicker(statusInterval)
+	defer status.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-watchdog:
+			if s.healthy() {
+				s.notify("WATCHDOG=1")
+			}
+		case <-status.C:
+			s.notify(s.status())
+		}
+	}
+}
+
+func (s *httpServer) status() string {
+	return fmt.Sprintf("STATUS=Serving since %s: %d requests, %d in flight",
+		s.startedAt.Format(time.RFC3339), atomic.LoadUint64(&s.counter), atomic.LoadInt64(&s.inflight))
+}
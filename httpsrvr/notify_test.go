@@ -0,0 +1,197 @@
+package httpsrvr
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+// envTestSystemd makes the test binary print the listeners it gets activated
+// by a fake systemd, see activatedProcess.
+const envTestSystemd = "HTTPSRVR_TEST_SYSTEMD"
+
+// activatedProcess is run by the test binary started by TestSystemdActivation
+// with the sockets in the systemd environment. LISTEN_PID can't be known in
+// advance, so it is set here.
+func activatedProcess() {
+
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	s := NewServer(0, false).ListenSystemd("https", &tls.Config{})
+	s.log = &recordingLogger{}
+	listeners, err := s.systemdListeners(nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	for _, l := range listeners {
+		fmt.Println(l)
+	}
+	os.Exit(0)
+}
+
+func listenTCP(t *testing.T) net.Listener {
+
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ln
+}
+
+func TestSystemdActivation(t *testing.T) {
+
+	if activatedListeners == nil {
+		t.Skip("systemd socket activation is only available on linux")
+	}
+
+	var files []*os.File
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		ln := listenTCP(t)
+		defer ln.Close()
+		f, err := ln.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+		addrs = append(addrs, ln.Addr().String())
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(executable)
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), envTestSystemd+"=1", "LISTEN_FDS=3", "LISTEN_FDNAMES=https:http:https")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	want := strings.Join([]string{
+		"systemd http " + addrs[1],
+		"systemd https " + addrs[0] + " (tls)",
+		"systemd https " + addrs[2] + " (tls)",
+	}, "\n") + "\n"
+	if string(out) != want {
+		t.Errorf("got\n%swant\n%s", out, want)
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+
+	defer func(f func() (map[string][]net.Listener, error)) { activatedListeners = f }(activatedListeners)
+
+	http, https1, https2, admin := listenTCP(t), listenTCP(t), listenTCP(t), listenTCP(t)
+	for _, ln := range []net.Listener{http, https1, https2, admin} {
+		defer ln.Close()
+	}
+	activated := map[string][]net.Listener{"http": {http}, "https": {https1, https2}, "admin": {admin}}
+	activatedListeners = func() (map[string][]net.Listener, error) { return activated, nil }
+	certFile, keyFile := writeCert(t, t.TempDir(), "site", "example.com")
+	adminTLS := &tls.Config{}
+
+	tests := []struct {
+		name  string
+		setup func(*httpServer)
+		want  []string
+		err   string
+	}{
+		{"plain", func(s *httpServer) {}, []string{"admin", "http", "https", "https"}, ""},
+		{"default tls", func(s *httpServer) { s.WithTLS(certFile, keyFile) }, []string{"admin (tls)", "http (tls)", "https (tls)", "https (tls)"}, ""},
+		{"named", func(s *httpServer) {
+			s.WithTLS(certFile, keyFile).ListenSystemd("http", nil).ListenSystemd("admin", adminTLS)
+		}, []string{"admin (admin tls)", "http", "https (tls)", "https (tls)"}, ""},
+		{"unknown name", func(s *httpServer) { s.ListenSystemd("api", nil) }, nil, `no systemd socket named "api"`},
+	}
+	for _, test := range tests {
+		s := NewServer(0, false).WithSystemd(true)
+		test.setup(s)
+		listeners, err := s.systemdListeners(nil)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var got []string
+		for _, l := range listeners {
+			name := strings.Fields(l.addr)[0]
+			switch {
+			case l.tls == adminTLS:
+				name += " (admin tls)"
+			case l.tls != nil:
+				name += " (tls)"
+			}
+			got = append(got, name)
+		}
+		if strings.Join(got, ", ") != strings.Join(test.want, ", ") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+
+	activatedListeners = func() (map[string][]net.Listener, error) { return nil, nil }
+	if _, err := NewServer(0, false).systemdListeners(nil); err == nil {
+		t.Error("no error without activated sockets")
+	}
+}
+
+func TestSystemdListenersInherited(t *testing.T) {
+
+	defer func(f func() (map[string][]net.Listener, error)) { activatedListeners = f }(activatedListeners)
+	activatedListeners = func() (map[string][]net.Listener, error) {
+		return nil, errors.New("sockets are inherited after an upgrade")
+	}
+
+	https, other := listenTCP(t), listenTCP(t)
+	defer https.Close()
+	defer other.Close()
+	inherited := map[string]net.Listener{
+		"systemd https " + https.Addr().String(): https,
+		"tcp " + other.Addr().String():           other,
+	}
+	listeners, err := NewServer(0, false).ListenSystemd("https", &tls.Config{}).systemdListeners(inherited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 1 || listeners[0].ln != https || listeners[0].tls == nil {
+		t.Errorf("got %v", listeners)
+	}
+	if len(inherited) != 1 {
+		t.Errorf("systemd listener not taken from %v", inherited)
+	}
+}
+
+func TestStatus(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.startedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.counter, s.inflight = 1234, 3
+	if got := s.status(); got != "STATUS=Serving since 2024-05-01T12:00:00Z: 1234 requests, 3 in flight" {
+		t.Errorf("got %q", got)
+	}
+
+	var states []string
+	defer func(f func(string)) { sdNotify = f }(sdNotify)
+	sdNotify = func(state string) { states = append(states, state) }
+	s.notify(s.status())
+	if len(states) != 1 || !regexp.MustCompile(`^STATUS=Serving since \S+: \d+ requests, \d+ in flight$`).MatchString(states[0]) {
+		t.Errorf("got %q", states)
+	}
+}
@@ -0,0 +1,108 @@
+package httpsrvr
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ihleven/errors"
+)
+
+type contextKey int
+
+// PathParam is a single path segment captured by a {name} route segment.
+type PathParam struct {
+	Key   string
+	Value string
+}
+
+// PathParams are the parameters of a matched route in path order.
+type PathParams []PathParam
+
+// Get returns the value of the first parameter called name or "".
+func (ps PathParams) Get(name string) string {
+	for _, p := range ps {
+		if p.Key == name {
+			return p.Value
+		}
+	}
+	return ""
+}
+
+// ParamsFromContext returns the path parameters stored in ctx by the server.
+func ParamsFromContext(ctx context.Context) PathParams {
+	if info := requestInfoFrom(ctx); info != nil {
+		return info.params
+	}
+	return nil
+}
+
+// Param returns the value of the path parameter name of the request.
+func Param(r *http.Request, name string) string {
+	return ParamsFromContext(r.Context()).Get(name)
+}
+
+// ParamInt returns the path parameter name of the request converted to int.
+func ParamInt(r *http.Request, name string) (int, error) {
+	i, err := strconv.Atoi(Param(r, name))
+	if err != nil {
+		return 0, errors.NewWithCode(http.StatusBadRequest, "path parameter %q is not an integer", name)
+	}
+	return i, nil
+}
+
+// pathParam describes a {name}, {name:int} or {name:regexp} route segment.
+type pathParam struct {
+	name    string
+	pattern string // constraint as written in the route, "" for plain parameters
+	re      *regexp.Regexp
+}
+
+var intParam = regexp.MustCompile(`^[0-9]+$`)
+
+// parseParam returns nil if segment is a literal segment.
+func parseParam(segment string) (*pathParam, error) {
+
+	if !strings.HasPrefix(segment, "{") || !strings.HasSuffix(segment, "}") {
+		return nil, nil
+	}
+	name, pattern := segment[1:len(segment)-1], ""
+	if i := strings.Index(name, ":"); i >= 0 {
+		name, pattern = name[:i], name[i+1:]
+	}
+	if name == "" {
+		return nil, errors.New("httpsrvr: missing parameter name in segment %q", segment)
+	}
+
+	p := pathParam{name: name, pattern: pattern}
+	switch pattern {
+	case "":
+	case "int":
+		p.re = intParam
+	default:
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, errors.Wrap(err, "httpsrvr: invalid pattern in segment %q", segment)
+		}
+		p.re = re
+	}
+	return &p, nil
+}
+
+// String returns the segment as written in the route.
+func (p *pathParam) String() string {
+	if p.pattern == "" {
+		return "{" + p.name + "}"
+	}
+	return "{" + p.name + ":" + p.pattern + "}"
+}
+
+func (p *pathParam) constrained() bool {
+	return p.re != nil
+}
+
+func (p *pathParam) match(segment string) bool {
+	return p.re == nil || p.re.MatchString(segment)
+}
@@ -0,0 +1,103 @@
+package httpsrvr
+
+import (
+	"encoding/json"
+	"html/template"
+	"strings"
+)
+
+// Problem is an RFC 7807 problem details object, the JSON representation of
+// errors returned by handlers.
+type Problem struct {
+	Type      string // URI identifying the problem type, "about:blank" if empty
+	Title     string // status text unless set by a ProblemDetailer
+	Status    int
+	Detail    string
+	Instance  string // request URI
+	RequestID string
+	Stack     string // %+v of the error, debug mode only
+	// Extensions are additional members like validation errors. Members of
+	// the same name as the ones above are ignored.
+	Extensions map[string]interface{}
+}
+
+// ProblemDetailer is implemented by errors that customize their problem
+// details, e.g. to set a type URI or add extension members:
+//
+//	func (e *OutOfCredit) ProblemDetails(p *httpsrvr.Problem) {
+//		p.Type = "https://example.com/probs/out-of-credit"
+//		p.Extensions["balance"] = e.Balance
+//	}
+//
+// It is looked for along the whole error chain.
+type ProblemDetailer interface {
+	ProblemDetails(p *Problem)
+}
+
+func (p *Problem) MarshalJSON() ([]byte, error) {
+
+	members := make(map[string]interface{}, len(p.Extensions)+7)
+	for k, v := range p.Extensions {
+		members[k] = v
+	}
+	members["type"] = p.Type
+	if p.Type == "" {
+		members["type"] = "about:blank"
+	}
+	members["title"] = p.Title
+	members["status"] = p.Status
+	for k, v := range map[string]string{"detail": p.Detail, "instance": p.Instance, "requestId": p.RequestID, "stack": p.Stack} {
+		if v != "" {
+			members[k] = v
+		} else {
+			delete(members, k)
+		}
+	}
+	return json.Marshal(members)
+}
+
+// FieldError is a single invalid input field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is answered with 422 Unprocessable Entity, its fields are
+// listed in the extension member "errors":
+//
+//	return httpsrvr.ValidationError{{"email", "must not be empty"}}
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+
+	msgs := make([]string, len(e))
+	for i, f := range e {
+		msgs[i] = f.Field + ": " + f.Message
+	}
+	return "invalid input: " + strings.Join(msgs, ", ")
+}
+
+// Code is the status code used by HandleError.
+func (e ValidationError) Code() int {
+	return 422
+}
+
+func (e ValidationError) ProblemDetails(p *Problem) {
+	p.Extensions["errors"] = []FieldError(e)
+}
+
+var problemPage = template.Must(template.New("problem").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Status}} {{.Title}}</title>
+</head>
+<body>
+<h1>{{.Status}} {{.Title}}</h1>
+{{if .Detail}}<p>{{.Detail}}</p>
+{{end}}{{range .FieldErrors}}<p>{{.Field}}: {{.Message}}</p>
+{{end}}{{if .RequestID}}<p><small>Request {{.RequestID}}</small></p>
+{{end}}{{if .Stack}}<pre>{{.Stack}}</pre>
+{{end}}</body>
+</html>
+`))
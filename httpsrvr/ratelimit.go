@@ -0,0 +1,261 @@
+package httpsrvr
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyFunc returns the key a request is rate limited by. Requests with empty
+// key are limited by client IP.
+type KeyFunc func(r *http.Request) string
+
+// ByIP limits requests per client IP. This is the default.
+func ByIP(r *http.Request) string {
+	return ""
+}
+
+// ByUser limits requests per user set with SetUser, so the policy must be
+// used after the authentication middleware.
+func ByUser(r *http.Request) string {
+
+	if info := requestInfoFrom(r.Context()); info != nil {
+		if user := info.username(); user != "" {
+			return "user " + user
+		}
+	}
+	return ""
+}
+
+// ByRoute limits requests per dispatcher name, i.e. all clients share a bucket.
+func ByRoute(r *http.Request) string {
+
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return "route " + info.name
+	}
+	return ""
+}
+
+// ByHeader limits requests per value of the given header, e.g. an API key.
+func ByHeader(name string) KeyFunc {
+
+	return func(r *http.Request) string {
+		if value := r.Header.Get(name); value != "" {
+			return "header " + value
+		}
+		return ""
+	}
+}
+
+// RateLimit is a token bucket rate limiting policy with one bucket per key.
+// It is attached to a server or dispatcher as middleware:
+//
+//	api := s.RateLimit(10, 20).By(httpsrvr.ByHeader("X-API-Key")).Exempt("10.0.0.0/8")
+//	s.Register("/api", h).Use(api.Middleware)
+type RateLimit struct {
+	rate     float64 // tokens per second
+	burst    int
+	key      KeyFunc
+	exempt   []*net.IPNet
+	max      int
+	mu       sync.Mutex
+	buckets  map[string]*list.Element
+	lru      *list.List // most recently used bucket first
+	rejected uint64
+	counter  *Counter // metric of the server, see httpServer.RateLimit
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimit returns a policy allowing r requests per second and bursts of
+// up to burst requests per client IP. Use httpServer.RateLimit to have its
+// rejections exported as metric.
+func NewRateLimit(r float64, burst int) *RateLimit {
+
+	return &RateLimit{
+		rate:    r,
+		burst:   burst,
+		key:     ByIP,
+		max:     10000,
+		buckets: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// RateLimit returns a policy like NewRateLimit whose rejections are counted in
+// the metric httpsrvr_ratelimit_rejected_total of the server.
+func (s *httpServer) RateLimit(r float64, burst int) *RateLimit {
+
+	l := NewRateLimit(r, burst)
+	l.counter = s.rateLimitRejected()
+	return l
+}
+
+// rateLimitRejected returns the counter shared by the global rate limiter and
+// the policies created with s.RateLimit.
+func (s *httpServer) rateLimitRejected() *Counter {
+	return s.metrics.Counter("httpsrvr_ratelimit_rejected_total", "Number of requests rejected by rate limiters.")
+}
+
+// By sets the key requests are limited by.
+func (l *RateLimit) By(key KeyFunc) *RateLimit {
+
+	l.key = key
+	return l
+}
+
+// Exempt excludes clients from the given networks in CIDR notation or single
+// IPs, e.g. internal networks. It panics on invalid input.
+func (l *RateLimit) Exempt(networks ...string) *RateLimit {
+
+	l.exempt = append(l.exempt, parseNetworks("exempt", networks)...)
+	return l
+}
+
+// MaxClients bounds the number of buckets kept in memory, 10000 by default.
+// The least recently used buckets are evicted first.
+func (l *RateLimit) MaxClients(n int) *RateLimit {
+
+	l.max = n
+	return l
+}
+
+// Rejected returns the number of rejected requests.
+func (l *RateLimit) Rejected() uint64 {
+	return atomic.LoadUint64(&l.rejected)
+}
+
+// Middleware sets the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset
+// headers and rejects requests exceeding the limit with 429 and Retry-After.
+func (l *RateLimit) Middleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		ip := clientIP(r)
+		if containsIP(l.exempt, ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := l.key(r)
+		if key == "" {
+			key = "ip " + ip.String()
+		}
+		ok, remaining, reset, retry := l.take(key, time.Now())
+
+		h := w.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(l.burst))
+		h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("RateLimit-Reset", seconds(reset))
+		if !ok {
+			atomic.AddUint64(&l.rejected, 1)
+			if l.counter != nil {
+				l.counter.Inc()
+			}
+			h.Set("Retry-After", seconds(retry))
+			http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// take takes a token from the bucket of key. It returns whether a token was
+// available, the remaining tokens, the duration until the bucket is full again
+// and the duration until the next token is available.
+func (l *RateLimit) take(key string, now time.Time) (ok bool, remaining int, reset, retry time.Duration) {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var b *bucket
+	if e, found := l.buckets[key]; found {
+		l.lru.MoveToFront(e)
+		b = e.Value.(*bucket)
+		b.tokens = math.Min(float64(l.burst), b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	} else {
+		b = &bucket{key: key, tokens: float64(l.burst), last: now}
+		l.buckets[key] = l.lru.PushFront(b)
+		for l.max > 0 && l.lru.Len() > l.max {
+			evicted := l.lru.Remove(l.lru.Back()).(*bucket)
+			delete(l.buckets, evicted.key)
+		}
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		ok = true
+	} else {
+		retry = l.duration(1 - b.tokens)
+	}
+	return ok, int(b.tokens), l.duration(float64(l.burst) - b.tokens), retry
+}
+
+// duration returns how long it takes to refill the given number of tokens.
+func (l *RateLimit) duration(tokens float64) time.Duration {
+
+	if l.rate <= 0 {
+		return 0
+	}
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+// parseNetworks parses networks in CIDR notation or single IPs. It panics on
+// invalid input, naming the networks as kind.
+func parseNetworks(kind string, networks []string) []*net.IPNet {
+
+	var nets []*net.IPNet
+	for _, network := range networks {
+		if ip := net.ParseIP(network); ip != nil {
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipnet, err := net.ParseCIDR(network)
+		if err != nil {
+			panic(fmt.Sprintf("httpsrvr: invalid %s network %q", kind, network))
+		}
+		nets = append(nets, ipnet)
+	}
+	return nets
+}
+
+// containsIP reports whether one of nets contains ip.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+
+	for _, n := range nets {
+		if ip != nil && n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the remote address of r.
+func clientIP(r *http.Request) net.IP {
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// seconds formats d as whole seconds rounded up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
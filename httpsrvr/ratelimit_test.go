@@ -0,0 +1,138 @@
+package httpsrvr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimitHeaders(t *testing.T) {
+
+	l := NewRateLimit(1, 2).Exempt("10.0.0.0/8")
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		remote                         string
+		status                         int
+		limit, remaining, reset, retry string
+	}{
+		{"192.0.2.1:1234", 200, "2", "1", "1", ""},
+		{"192.0.2.1:1234", 200, "2", "0", "2", ""},
+		{"192.0.2.1:1234", 429, "2", "0", "2", "1"},
+		{"192.0.2.2:1234", 200, "2", "1", "1", ""},
+		{"10.1.2.3:1234", 200, "", "", "", ""},
+		{"10.1.2.3:1234", 200, "", "", "", ""},
+		{"10.1.2.3:1234", 200, "", "", "", ""},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		got := []string{w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Remaining"), w.Header().Get("RateLimit-Reset"), w.Header().Get("Retry-After")}
+		want := []string{test.limit, test.remaining, test.reset, test.retry}
+		if w.Code != test.status || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] || got[3] != want[3] {
+			t.Errorf("%d %s: got %d %q, want %d %q", i, test.remote, w.Code, got, test.status, want)
+		}
+	}
+	if l.Rejected() != 1 {
+		t.Errorf("got %d rejected, want 1", l.Rejected())
+	}
+}
+
+func TestRateLimitByHeader(t *testing.T) {
+
+	l := NewRateLimit(1, 1).By(ByHeader("X-API-Key"))
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		key, remote string
+		status      int
+	}{
+		{"a", "192.0.2.1:1", 200},
+		{"a", "192.0.2.2:1", 429}, // same key from another IP
+		{"b", "192.0.2.1:1", 200},
+		{"", "192.0.2.1:1", 200}, // no key, limited by IP
+		{"", "192.0.2.1:1", 429},
+	}
+	for i, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.key != "" {
+			r.Header.Set("X-API-Key", test.key)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%d %q %s: got %d, want %d", i, test.key, test.remote, w.Code, test.status)
+		}
+	}
+}
+
+func TestRateLimitEviction(t *testing.T) {
+
+	l := NewRateLimit(0.001, 1).MaxClients(2)
+	now := time.Now()
+
+	steps := []struct {
+		key string
+		ok  bool
+	}{
+		{"a", true},
+		{"b", true},
+		{"a", false}, // a is now the most recently used
+		{"c", true},  // evicts b
+		{"a", false}, // kept
+		{"b", true},  // fresh bucket, evicts c
+		{"c", true},  // fresh bucket, evicts a
+		{"a", true},
+	}
+	for i, step := range steps {
+		if ok, _, _, _ := l.take(step.key, now); ok != step.ok {
+			t.Errorf("%d %s: got %v, want %v", i, step.key, ok, step.ok)
+		}
+		if len(l.buckets) > 2 || l.lru.Len() != len(l.buckets) {
+			t.Fatalf("%d: %d buckets, %d in LRU list", i, len(l.buckets), l.lru.Len())
+		}
+	}
+	if _, ok := l.buckets["b"]; ok {
+		t.Error("b not evicted")
+	}
+}
+
+func TestRateLimitRefill(t *testing.T) {
+
+	l := NewRateLimit(2, 2)
+	now := time.Now()
+	l.take("a", now)
+	l.take("a", now)
+	if ok, _, _, retry := l.take("a", now); ok || retry != 500*time.Millisecond {
+		t.Errorf("empty bucket: got %v, retry %v", ok, retry)
+	}
+	if ok, remaining, _, _ := l.take("a", now.Add(time.Second)); !ok || remaining != 1 {
+		t.Errorf("after refill: got %v, %d remaining", ok, remaining)
+	}
+}
+
+func TestRateLimitMetric(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.logger = discardAccessLog{}
+	api := s.RateLimit(0.001, 1)
+	s.Register("/api", http.NotFoundHandler()).Use(api.Middleware)
+	s.Register("/free", http.NotFoundHandler()).Use(NewRateLimit(0.001, 1).Middleware)
+
+	for _, path := range []string{"/api", "/api", "/api", "/free", "/free"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if api.Rejected() != 2 {
+		t.Errorf("got %d rejected, want 2", api.Rejected())
+	}
+	var b strings.Builder
+	s.Metrics().WriteTo(&b)
+	if !strings.Contains(b.String(), "\nhttpsrvr_ratelimit_rejected_total 2\n") {
+		t.Errorf("rejections not exported:\n%s", b.String())
+	}
+}
@@ -0,0 +1,70 @@
+package httpsrvr
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicSink receives panics of handlers, e.g. to report them to an error
+// tracker. stack is the stack trace of the panicking goroutine.
+type PanicSink func(r *http.Request, value interface{}, stack []byte)
+
+// OnPanic sets the sink panics are reported to instead of the server log.
+func (s *httpServer) OnPanic(sink PanicSink) *httpServer {
+
+	s.panicSink = sink
+	return s
+}
+
+// panicError is the error a panic is answered with via HandleError. Its
+// message is generic, the value and stack are only printed with %+v, i.e. in
+// debug mode.
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return http.StatusText(http.StatusInternalServerError)
+}
+
+func (e *panicError) Format(f fmt.State, verb rune) {
+
+	if verb == 'v' && f.Flag('+') {
+		fmt.Fprintf(f, "panic: %v\n\n%s", e.value, e.stack)
+		return
+	}
+	io.WriteString(f, e.Error())
+}
+
+// recovered handles the panic value raised while serving r. It answers with
+// 500 unless the response has been started already, in which case the
+// connection must be aborted so the client does not take the partial response
+// for a complete one. http.ErrAbortHandler is not reported.
+func (s *httpServer) recovered(rw *ResponseWriter, r *http.Request, value interface{}, showStack bool) (abort bool) {
+
+	if value == http.ErrAbortHandler {
+		return true
+	}
+
+	stack := debug.Stack()
+	if s.panicSink != nil {
+		s.panicSink(r, value, stack)
+	} else {
+		s.log.Info("panic serving %s %s: %v\n%s", r.Method, r.RequestURI, value, stack)
+	}
+
+	if rw.written {
+		return true
+	}
+	// drop representation headers of the failed response
+	h := rw.Header()
+	for _, key := range []string{"Content-Length", "Content-Encoding", "Content-Range", "Content-Disposition", "ETag", "Last-Modified"} {
+		h.Del(key)
+	}
+	h.Set("Cache-Control", "no-store")
+	HandleError(rw, r, &panicError{value, stack}, showStack)
+	return false
+}
@@ -0,0 +1,100 @@
+package httpsrvr
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		debug   bool
+		handler http.HandlerFunc
+		status  int
+		abort   bool
+		report  bool
+	}{
+		{"panic", false, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"abc"`)
+			panic("secret state")
+		}, 500, false, true},
+		{"panic in debug mode", true, func(w http.ResponseWriter, r *http.Request) {
+			panic("secret state")
+		}, 500, false, true},
+		{"panic with error", false, func(w http.ResponseWriter, r *http.Request) {
+			panic(io.ErrUnexpectedEOF)
+		}, 500, false, true},
+		{"panic after partial write", false, func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "partial")
+			panic("secret state")
+		}, 200, true, true},
+		{"abort", false, func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		}, 200, true, false},
+		{"abort after partial write", false, func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "partial")
+			panic(http.ErrAbortHandler)
+		}, 200, true, false},
+	}
+
+	for _, test := range tests {
+		s := NewServer(0, test.debug)
+		s.logger = &entryRecorder{}
+		var reported interface{}
+		s.OnPanic(func(r *http.Request, value interface{}, stack []byte) { reported = value })
+		s.Register("/", test.handler)
+
+		w := httptest.NewRecorder()
+		var repanicked interface{}
+		func() {
+			defer func() { repanicked = recover() }()
+			s.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+		}()
+
+		if test.abort != (repanicked == http.ErrAbortHandler) {
+			t.Errorf("%s: got panic %v", test.name, repanicked)
+		}
+		if test.report != (reported != nil) {
+			t.Errorf("%s: got reported %v", test.name, reported)
+		}
+		if w.Code != test.status {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.status)
+		}
+		if test.abort {
+			continue
+		}
+		if w.Header().Get("ETag") != "" || w.Header().Get("Cache-Control") != "no-store" {
+			t.Errorf("%s: got headers %v", test.name, w.Header())
+		}
+		if strings.Contains(w.Body.String(), "secret state") != test.debug {
+			t.Errorf("%s: got body %q", test.name, w.Body)
+		}
+	}
+}
+
+func TestRecoverAbortsConnection(t *testing.T) {
+
+	s := NewServer(0, false)
+	s.OnPanic(func(r *http.Request, value interface{}, stack []byte) {})
+	s.Register("/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "partial")
+		w.(http.Flusher).Flush()
+		panic("broken")
+	})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// the client must not take the partial body for a complete response
+	if body, err := io.ReadAll(resp.Body); err == nil {
+		t.Errorf("got complete response %d %q", resp.StatusCode, body)
+	}
+}
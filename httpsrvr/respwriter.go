@@ -1,26 +1,33 @@
 package httpsrvr
 
 import (
+	"bufio"
+	"net"
 	"net/http"
 	"sync/atomic"
+
+	"github.com/ihleven/errors"
 )
 
 // ResponseWriter intercepts http.ResponseWriter  capturing the response status code
 type ResponseWriter struct {
 	http.ResponseWriter
-	count      uint64
-	statusCode int
+	count        uint64
+	uncompressed uint64 // body size before compression, set by the compression middleware
+	statusCode   int
+	written      bool // whether the header has been sent
 }
 
 // NewResponseWriter wraps given http.ResponseWriter in a ResponseWriter overwriting the WriteHeader method
 func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
 	// WriteHeader(int) is not called if our response implicitly returns 200 OK, so
 	// we default to that status code.
-	return &ResponseWriter{w, 0, http.StatusOK}
+	return &ResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 }
 
 // Write captures the response size
 func (rw *ResponseWriter) Write(buf []byte) (int, error) {
+	rw.written = true
 	n, err := rw.ResponseWriter.Write(buf)
 	atomic.AddUint64(&rw.count, uint64(n))
 	return n, err
@@ -28,7 +35,11 @@ func (rw *ResponseWriter) Write(buf []byte) (int, error) {
 
 // WriteHeader captures the response status
 func (rw *ResponseWriter) WriteHeader(code int) {
+	if rw.written {
+		return
+	}
 	rw.statusCode = code
+	rw.written = code >= 200
 	rw.ResponseWriter.WriteHeader(code)
 }
 
@@ -36,3 +47,50 @@ func (rw *ResponseWriter) WriteHeader(code int) {
 func (rw *ResponseWriter) Count() uint64 {
 	return atomic.LoadUint64(&rw.count)
 }
+
+// Uncompressed returns the body size before compression, which is Count for
+// uncompressed responses.
+func (rw *ResponseWriter) Uncompressed() uint64 {
+	if n := atomic.LoadUint64(&rw.uncompressed); n > 0 {
+		return n
+	}
+	return rw.Count()
+}
+
+// Flush sends buffered data to the client if the underlying writer supports it.
+func (rw *ResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets handlers take over the connection, e.g. for WebSocket upgrades.
+func (rw *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer %T does not support hijacking", rw.ResponseWriter)
+	}
+	rw.statusCode, rw.written = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped http.ResponseWriter for http.ResponseController.
+func (rw *ResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// unwrapResponseWriter returns the ResponseWriter of the server wrapped by w
+// or nil.
+func unwrapResponseWriter(w http.ResponseWriter) *ResponseWriter {
+
+	for {
+		switch v := w.(type) {
+		case *ResponseWriter:
+			return v
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
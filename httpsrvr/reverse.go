@@ -0,0 +1,84 @@
+package httpsrvr
+
+import (
+	"fmt"
+	"html/template"
+	"net/url"
+	"strings"
+
+	"github.com/ihleven/errors"
+)
+
+// URL builds the path of the route called name. Parameters are given as
+// alternating key and value pairs, e.g. URL("photo-detail", "id", 42, "photo", "x").
+// Values have to satisfy the constraint of their parameter. Routes of virtual
+// hosts are found as well, the default routes are searched first.
+func (s *httpServer) URL(name string, pairs ...interface{}) (string, error) {
+
+	if len(pairs)%2 != 0 {
+		return "", errors.New("odd number of parameters for route %q", name)
+	}
+	params := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		params[fmt.Sprint(pairs[i])] = fmt.Sprint(pairs[i+1])
+	}
+
+	pattern := s.routePattern(name)
+	if pattern == "" {
+		return "", errors.NewWithCode(errors.NotFound, "no route named %q", name)
+	}
+
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		param, err := parseParam(segment)
+		if err != nil {
+			return "", err
+		}
+		if param == nil {
+			continue
+		}
+		value, ok := params[param.name]
+		if !ok {
+			return "", errors.New("missing parameter %q for route %q", param.name, name)
+		}
+		if !param.match(value) {
+			return "", errors.New("parameter %q of route %q does not match %s: %q", param.name, name, param, value)
+		}
+		segments[i] = url.PathEscape(value)
+	}
+	return strings.Join(segments, "/"), nil
+}
+
+// routePattern returns the pattern of the route called name or "".
+func (s *httpServer) routePattern(name string) string {
+
+	var pattern string
+	for _, routes := range append([]*dispatcher{s.routes}, s.hostRoutes()...) {
+		routes.node.walk(func(d *dispatcher) {
+			if pattern == "" && d.name == name && (d.any || len(d.methods) > 0) {
+				pattern = d.pattern
+			}
+		})
+		if pattern != "" {
+			break
+		}
+	}
+	return pattern
+}
+
+// MustURL is like URL but panics if the URL cannot be built.
+func (s *httpServer) MustURL(name string, pairs ...interface{}) string {
+
+	u, err := s.URL(name, pairs...)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// FuncMap returns template functions for html/template, currently
+//
+//	{{url "photo-detail" "id" .ID}}
+func (s *httpServer) FuncMap() template.FuncMap {
+	return template.FuncMap{"url": s.URL}
+}
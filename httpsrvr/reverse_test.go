@@ -0,0 +1,95 @@
+package httpsrvr
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ihleven/errors"
+)
+
+func reverseServer() *httpServer {
+
+	s := NewServer(0, false)
+	h := http.NotFoundHandler()
+	s.Register("/", h).Name("home")
+	s.Register("/users/{id:int}", h).Name("user")
+	s.Register("/users/{id:int}/photos/{photo}", h).Name("photo-detail")
+	s.Register("/tags/{tag:[a-z]+}", h).Name("tag")
+	s.Register("/files/{name}", nil).Get(h).Name("file")
+	s.Register("/unbound/{x}", nil).Name("unbound")
+	return s
+}
+
+func TestURL(t *testing.T) {
+
+	s := reverseServer()
+	tests := []struct {
+		name  string
+		pairs []interface{}
+		want  string
+		err   string
+	}{
+		{"home", nil, "/", ""},
+		{"user", []interface{}{"id", 42}, "/users/42", ""},
+		{"photo-detail", []interface{}{"photo", "x", "id", 42}, "/users/42/photos/x", ""},
+		{"file", []interface{}{"name", "a b/c.txt"}, "/files/a%20b%2Fc.txt", ""},
+		{"user", []interface{}{"id", 42, "unused", 1}, "/users/42", ""},
+		{"user", []interface{}{"id", "abc"}, "", `parameter "id" of route "user" does not match {id:int}`},
+		{"tag", []interface{}{"tag", "Go"}, "", `does not match {tag:[a-z]+}`},
+		{"user", nil, "", `missing parameter "id"`},
+		{"photo-detail", []interface{}{"id", 42}, "", `missing parameter "photo"`},
+		{"user", []interface{}{"id"}, "", "odd number of parameters"},
+		{"unknown", nil, "", `no route named "unknown"`},
+		{"unbound", []interface{}{"x", 1}, "", `no route named "unbound"`},
+	}
+	for _, test := range tests {
+		got, err := s.URL(test.name, test.pairs...)
+		if got != test.want || (err == nil) != (test.err == "") || err != nil && !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s %v: got %q, %v, want %q, %s", test.name, test.pairs, got, err, test.want, test.err)
+		}
+	}
+	if _, err := s.URL("unknown"); errors.Code(err) != http.StatusNotFound {
+		t.Errorf("got code %v for unknown route", errors.Code(err))
+	}
+}
+
+func TestMustURL(t *testing.T) {
+
+	s := reverseServer()
+	if got := s.MustURL("user", "id", 7); got != "/users/7" {
+		t.Errorf("got %q", got)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for unknown route")
+		}
+	}()
+	s.MustURL("unknown")
+}
+
+func TestFuncMap(t *testing.T) {
+
+	s := reverseServer()
+	tmpl := template.Must(template.New("").Funcs(s.FuncMap()).Parse(`<a href="{{url "photo-detail" "id" .ID "photo" .Photo}}">`))
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, struct {
+		ID    int
+		Photo string
+	}{42, "a&b"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := `<a href="/users/42/photos/a&amp;b">`; b.String() != want {
+		t.Errorf("got %s, want %s", b.String(), want)
+	}
+
+	b.Reset()
+	if err := tmpl.Execute(&b, struct {
+		ID    string
+		Photo string
+	}{"abc", "x"}); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("got %v, want an error for the invalid id", err)
+	}
+}
@@ -0,0 +1,146 @@
+package httpsrvr
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Route describes a route registered with a dispatcher.
+type Route struct {
+	Host         string   `json:"host,omitempty"` // virtual host pattern, empty for default routes
+	Path         string   `json:"path"`
+	Name         string   `json:"name"`
+	Methods      []string `json:"methods,omitempty"` // empty if any method is served
+	PreservePath bool     `json:"preservePath"`
+	Middleware   []string `json:"middleware,omitempty"` // effective middleware, outermost first, function literals as "anonymous"
+}
+
+// Routes returns all routes of the server sorted by path, default routes
+// first followed by those of the virtual hosts.
+func (s *httpServer) Routes() []Route {
+
+	routes := s.routes.Routes()
+	for _, d := range s.hostRoutes() {
+		for _, route := range d.Routes() {
+			route.Host = d.name
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+// Routes walks the dispatcher tree and returns every route with a handler
+// beneath and including r. Paths are relative to r.
+func (r *dispatcher) Routes() []Route {
+
+	var routes []Route
+	r.node.walk(func(d *dispatcher) {
+		if !d.any && len(d.methods) == 0 {
+			return
+		}
+		path := d.pattern
+		if r.pattern != "/" {
+			path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, r.pattern), "/")
+		}
+		routes = append(routes, Route{
+			Path:         path,
+			Name:         d.name,
+			Methods:      d.Allowed(),
+			PreservePath: d.preserve,
+			Middleware:   d.middlewareNames(),
+		})
+	})
+	return routes
+}
+
+func (d *dispatcher) middlewareNames() []string {
+
+	var names []string
+	for n := d; n != nil; n = n.parent {
+		for i := len(n.use) - 1; i >= 0; i-- {
+			names = append(names, middlewareName(n.use[i]))
+		}
+		if n.isolated {
+			break
+		}
+	}
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+	return names
+}
+
+// closure matches the suffix of function names of function literals.
+var closure = regexp.MustCompile(`\.(glob\.)?func\d+(\.\d+)*$`)
+
+// middlewareName returns the name of a package level function like
+// "auth.Logging", the type and method of a method value like
+// "httpsrvr.CORS.Middleware" or "anonymous" for function literals.
+func middlewareName(m Middleware) string {
+
+	fn := runtime.FuncForPC(reflect.ValueOf(m).Pointer())
+	if fn == nil {
+		return "anonymous"
+	}
+	// strip the package path, e.g. github.com/ihleven/pkg/
+	name := fn.Name()
+	name = name[strings.LastIndexByte(name, '/')+1:]
+	if strings.HasSuffix(name, "-fm") {
+		return strings.NewReplacer("(*", "", "(", "", ")", "").Replace(strings.TrimSuffix(name, "-fm"))
+	}
+	if closure.MatchString(name) {
+		return "anonymous"
+	}
+	return name
+}
+
+// RoutesHandler renders the route table as JSON if requested via Accept header
+// or ?format=json and as HTML otherwise. It is meant to be registered in debug
+// setups, e.g. s.Register("/debug/routes", s.RoutesHandler()).
+func (s *httpServer) RoutesHandler() http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		routes := s.Routes()
+
+		if r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(routes)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := routesTemplate.Execute(w, routes); err != nil {
+			s.log.Info("Could not render route table: %v", err)
+		}
+	})
+}
+
+var routesTemplate = template.Must(template.New("routes").Funcs(template.FuncMap{"join": strings.Join}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>Routes</title></head>
+<body>
+<table>
+<tr><th>Host</th><th>Path</th><th>Name</th><th>Methods</th><th>Preserve path</th><th>Middleware</th></tr>
+{{range .}}<tr><td>{{.Host}}</td><td>{{.Path}}</td><td>{{.Name}}</td><td>{{if .Methods}}{{join .Methods ", "}}{{else}}*{{end}}</td><td>{{.PreservePath}}</td><td>{{join .Middleware ", "}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// logRoutes writes the route table to the debug log.
+func (s *httpServer) logRoutes() {
+
+	for _, route := range s.Routes() {
+		methods := "*"
+		if route.Methods != nil {
+			methods = strings.Join(route.Methods, ",")
+		}
+		s.log.Debug("route %s%-30s %-20s %-30s preserve=%v %s", route.Host, route.Path, route.Name, methods, route.PreservePath, strings.Join(route.Middleware, " > "))
+	}
+}
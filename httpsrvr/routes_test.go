@@ -0,0 +1,116 @@
+package httpsrvr
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func noopMiddleware(next http.Handler) http.Handler {
+	return next
+}
+
+func routesServer() *httpServer {
+
+	h := http.NotFoundHandler()
+	s := NewServer(0, false)
+	s.Use(noopMiddleware)
+	s.Register("/", h).Name("home")
+	api := s.Register("/api", nil).Use(NewCORS().Middleware)
+	api.Register("/users/{id:int}", nil).Get(h).Delete(h).Use(trace("users"))
+	s.Register("/static", h).PreservePath(true).Isolate(true)
+	s.Host("*.example.org").Register("/blog", h)
+	return s
+}
+
+func TestRoutes(t *testing.T) {
+
+	want := []Route{
+		{Path: "/", Name: "home", Middleware: []string{"httpsrvr.noopMiddleware"}},
+		{Path: "/api/users/{id:int}", Name: "{id:int}", Methods: []string{"DELETE", "GET", "HEAD", "OPTIONS"}, Middleware: []string{"httpsrvr.noopMiddleware", "httpsrvr.CORS.Middleware", "anonymous"}},
+		{Path: "/static", Name: "static", PreservePath: true},
+		{Host: "*.example.org", Path: "/blog", Name: "blog", Middleware: []string{"httpsrvr.noopMiddleware"}},
+	}
+	if got := routesServer().Routes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDispatcherRoutes(t *testing.T) {
+
+	root := NewDispatcher(nil, "root")
+	api := root.Register("/api", nil)
+	api.Register("/users", http.NotFoundHandler())
+	api.Register("/users/{id}/photos", http.NotFoundHandler())
+	root.Register("/other", http.NotFoundHandler())
+
+	var paths []string
+	for _, route := range api.Routes() {
+		paths = append(paths, route.Path)
+	}
+	if got := strings.Join(paths, " "); got != "/users /users/{id}/photos" {
+		t.Errorf("got %s", got)
+	}
+}
+
+func TestRoutesHandler(t *testing.T) {
+
+	s := routesServer()
+	tests := []struct {
+		url, accept, contentType string
+	}{
+		{"/debug/routes", "", "text/html; charset=utf-8"},
+		{"/debug/routes", "application/json", "application/json"},
+		{"/debug/routes?format=json", "", "application/json"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.url, nil)
+		if test.accept != "" {
+			r.Header.Set("Accept", test.accept)
+		}
+		w := httptest.NewRecorder()
+		s.RoutesHandler().ServeHTTP(w, r)
+		if ct := w.Header().Get("Content-Type"); ct != test.contentType {
+			t.Errorf("%s %q: got %s", test.url, test.accept, ct)
+			continue
+		}
+
+		if test.contentType == "application/json" {
+			var routes []Route
+			if err := json.Unmarshal(w.Body.Bytes(), &routes); err != nil || !reflect.DeepEqual(routes, s.Routes()) {
+				t.Errorf("%s: got %v %s", test.url, err, w.Body)
+			}
+			continue
+		}
+		for _, want := range []string{
+			"<td>/api/users/{id:int}</td><td>{id:int}</td><td>DELETE, GET, HEAD, OPTIONS</td><td>false</td><td>httpsrvr.noopMiddleware, httpsrvr.CORS.Middleware, anonymous</td>",
+			"<td>*.example.org</td><td>/blog</td><td>blog</td><td>*</td>",
+		} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("%s: %s missing in\n%s", test.url, want, w.Body)
+			}
+		}
+	}
+}
+
+func TestMiddlewareName(t *testing.T) {
+
+	tests := []struct {
+		m    Middleware
+		want string
+	}{
+		{noopMiddleware, "httpsrvr.noopMiddleware"},
+		{NewCORS().Middleware, "httpsrvr.CORS.Middleware"},
+		{NewCompression().Middleware, "httpsrvr.Compression.Middleware"},
+		{trace("x"), "anonymous"},
+		{func(next http.Handler) http.Handler { return next }, "anonymous"},
+	}
+	for _, test := range tests {
+		if got := middlewareName(test.m); got != test.want {
+			t.Errorf("got %s, want %s", got, test.want)
+		}
+	}
+}
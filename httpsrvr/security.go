@@ -0,0 +1,405 @@
+package httpsrvr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SecurityHeaders sets security related response headers. Attach it to the
+// server or a dispatcher subtree as middleware:
+//
+//	csp := httpsrvr.NewCSP().Directive("img-src", "'self'", "data:").Nonce("script-src", "style-src")
+//	s.Use(httpsrvr.NewSecurityHeaders().CSP(csp).Middleware)
+type SecurityHeaders struct {
+	hsts              string
+	frameOptions      string
+	referrerPolicy    string
+	permissionsPolicy string
+	csp               *CSP
+}
+
+// NewSecurityHeaders returns headers with defaults suitable for most sites:
+// HSTS for a year on HTTPS requests, nosniff, framing denied, referrers only
+// sent in full to the same origin and no camera, microphone or geolocation.
+func NewSecurityHeaders() *SecurityHeaders {
+
+	return &SecurityHeaders{
+		hsts:              "max-age=31536000",
+		frameOptions:      "DENY",
+		referrerPolicy:    "strict-origin-when-cross-origin",
+		permissionsPolicy: "camera=(), microphone=(), geolocation=()",
+	}
+}
+
+// HSTS sets Strict-Transport-Security, sent on HTTPS requests only, see
+// IsHTTPS. A zero maxAge disables the header.
+func (h *SecurityHeaders) HSTS(maxAge time.Duration, includeSubdomains, preload bool) *SecurityHeaders {
+
+	h.hsts = ""
+	if maxAge > 0 {
+		h.hsts = "max-age=" + strconv.Itoa(int(maxAge.Seconds()))
+		if includeSubdomains {
+			h.hsts += "; includeSubDomains"
+		}
+		if preload {
+			h.hsts += "; preload"
+		}
+	}
+	return h
+}
+
+// FrameOptions sets X-Frame-Options to "DENY", "SAMEORIGIN" or "" to omit it.
+func (h *SecurityHeaders) FrameOptions(value string) *SecurityHeaders {
+
+	h.frameOptions = value
+	return h
+}
+
+// ReferrerPolicy sets Referrer-Policy, "" omits it.
+func (h *SecurityHeaders) ReferrerPolicy(value string) *SecurityHeaders {
+
+	h.referrerPolicy = value
+	return h
+}
+
+// PermissionsPolicy sets Permissions-Policy, "" omits it.
+func (h *SecurityHeaders) PermissionsPolicy(value string) *SecurityHeaders {
+
+	h.permissionsPolicy = value
+	return h
+}
+
+// CSP sets the Content-Security-Policy.
+func (h *SecurityHeaders) CSP(csp *CSP) *SecurityHeaders {
+
+	h.csp = csp
+	return h
+}
+
+func (h *SecurityHeaders) Middleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		header := w.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		if h.hsts != "" && IsHTTPS(r) {
+			header.Set("Strict-Transport-Security", h.hsts)
+		}
+		if h.frameOptions != "" {
+			header.Set("X-Frame-Options", h.frameOptions)
+		}
+		if h.referrerPolicy != "" {
+			header.Set("Referrer-Policy", h.referrerPolicy)
+		}
+		if h.permissionsPolicy != "" {
+			header.Set("Permissions-Policy", h.permissionsPolicy)
+		}
+		if h.csp != nil {
+			r = h.csp.set(header, r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSP builds a Content-Security-Policy. Directives can carry a nonce generated
+// per request, which templates get via Nonce:
+//
+//	<script nonce="{{nonce}}">...</script>
+type CSP struct {
+	directives []cspDirective
+	nonce      []string // directives getting the nonce
+	reportOnly bool
+	reportURI  string
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+// NewCSP returns a policy starting with
+// "default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'".
+func NewCSP() *CSP {
+
+	return &CSP{directives: []cspDirective{
+		{"default-src", []string{"'self'"}},
+		{"object-src", []string{"'none'"}},
+		{"base-uri", []string{"'self'"}},
+		{"frame-ancestors", []string{"'none'"}},
+	}}
+}
+
+// Directive replaces the sources of the directive name, e.g.
+// Directive("img-src", "'self'", "data:"). Without sources the directive is
+// sent without value like "upgrade-insecure-requests".
+func (c *CSP) Directive(name string, sources ...string) *CSP {
+
+	for i := range c.directives {
+		if c.directives[i].name == name {
+			c.directives[i].sources = sources
+			return c
+		}
+	}
+	c.directives = append(c.directives, cspDirective{name, sources})
+	return c
+}
+
+// Nonce adds a per-request nonce to the given directives, script-src and
+// style-src by default. Missing directives start with the default-src sources.
+func (c *CSP) Nonce(directives ...string) *CSP {
+
+	if len(directives) == 0 {
+		directives = []string{"script-src", "style-src"}
+	}
+	c.nonce = append(c.nonce, directives...)
+	return c
+}
+
+// ReportOnly sends the policy as Content-Security-Policy-Report-Only, so
+// violations are reported but not blocked.
+func (c *CSP) ReportOnly(enabled bool) *CSP {
+
+	c.reportOnly = enabled
+	return c
+}
+
+// ReportURI sets where browsers post violation reports, see
+// httpServer.WithCSPReportEndpoint.
+func (c *CSP) ReportURI(uri string) *CSP {
+
+	c.reportURI = uri
+	return c
+}
+
+// String returns the policy with the nonce placeholder "{nonce}".
+func (c *CSP) String() string {
+	return c.policy("{nonce}")
+}
+
+func (c *CSP) policy(nonce string) string {
+
+	directives := c.directives
+	for _, name := range c.nonce {
+		if !c.has(name) {
+			directives = append(directives[:len(directives):len(directives)], cspDirective{name, c.sources("default-src")})
+		}
+	}
+
+	var b strings.Builder
+	for i, d := range directives {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(d.name)
+		for _, source := range d.sources {
+			b.WriteString(" " + source)
+		}
+		if nonce != "" && contains(c.nonce, d.name) {
+			b.WriteString(" 'nonce-" + nonce + "'")
+		}
+	}
+	if c.reportURI != "" {
+		b.WriteString("; report-uri " + c.reportURI + "; report-to csp")
+	}
+	return b.String()
+}
+
+func (c *CSP) has(name string) bool {
+
+	for _, d := range c.directives {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CSP) sources(name string) []string {
+
+	for _, d := range c.directives {
+		if d.name == name {
+			return d.sources
+		}
+	}
+	return nil
+}
+
+// set sets the policy header of the response to r. It returns r with the
+// generated nonce in its context, so Nonce works without the server, too.
+func (c *CSP) set(header http.Header, r *http.Request) *http.Request {
+
+	nonce := ""
+	if len(c.nonce) > 0 {
+		nonce = newNonce()
+		r = r.WithContext(context.WithValue(r.Context(), nonceKey, nonce))
+	}
+
+	name := "Content-Security-Policy"
+	if c.reportOnly {
+		name = "Content-Security-Policy-Report-Only"
+	}
+	header.Set(name, c.policy(nonce))
+	if c.reportURI != "" {
+		header.Set("Reporting-Endpoints", `csp="`+c.reportURI+`"`)
+	}
+	return r
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func newNonce() string {
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// Nonce returns the CSP nonce of the request for use in templates:
+//
+//	t := template.New("page").Funcs(template.FuncMap{"nonce": func() string { return httpsrvr.Nonce(r) }})
+func Nonce(r *http.Request) string {
+
+	nonce, _ := r.Context().Value(nonceKey).(string)
+	return nonce
+}
+
+// CSPViolation is a violation report sent by a browser, in the legacy
+// report-uri format or the format of the Reporting API.
+type CSPViolation struct {
+	DocumentURI        string `json:"documentURL"`
+	Referrer           string `json:"referrer,omitempty"`
+	BlockedURI         string `json:"blockedURL,omitempty"`
+	EffectiveDirective string `json:"effectiveDirective"`
+	OriginalPolicy     string `json:"originalPolicy,omitempty"`
+	Disposition        string `json:"disposition,omitempty"` // "enforce" or "report"
+	SourceFile         string `json:"sourceFile,omitempty"`
+	LineNumber         int    `json:"lineNumber,omitempty"`
+	ColumnNumber       int    `json:"columnNumber,omitempty"`
+	StatusCode         int    `json:"statusCode,omitempty"`
+}
+
+var cspDirectives = map[string]bool{
+	"default-src": true, "script-src": true, "script-src-elem": true, "script-src-attr": true,
+	"style-src": true, "style-src-elem": true, "style-src-attr": true, "img-src": true,
+	"font-src": true, "connect-src": true, "media-src": true, "object-src": true,
+	"frame-src": true, "child-src": true, "worker-src": true, "manifest-src": true,
+	"base-uri": true, "form-action": true, "frame-ancestors": true,
+}
+
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		BlockedURI         string `json:"blocked-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		StatusCode         int    `json:"status-code"`
+	} `json:"csp-report"`
+}
+
+// CSPReportHandler accepts violation reports and passes them to report.
+func CSPReportHandler(report func(r *http.Request, violation CSPViolation)) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		violations, err := parseCSPReports(r.Header.Get("Content-Type"), body)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		for _, v := range violations {
+			report(r, v)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func parseCSPReports(contentType string, body []byte) ([]CSPViolation, error) {
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "application/reports+json" {
+		var reports []struct {
+			Type string       `json:"type"`
+			Body CSPViolation `json:"body"`
+		}
+		if err := json.Unmarshal(body, &reports); err != nil {
+			return nil, err
+		}
+		var violations []CSPViolation
+		for _, report := range reports {
+			if report.Type == "csp-violation" {
+				violations = append(violations, report.Body)
+			}
+		}
+		return violations, nil
+	}
+
+	var legacy legacyCSPReport
+	if err := json.Unmarshal(body, &legacy); err != nil {
+		return nil, err
+	}
+	l := legacy.Report
+	directive := l.EffectiveDirective
+	if directive == "" {
+		directive = l.ViolatedDirective
+	}
+	return []CSPViolation{{
+		DocumentURI:        l.DocumentURI,
+		Referrer:           l.Referrer,
+		BlockedURI:         l.BlockedURI,
+		EffectiveDirective: directive,
+		OriginalPolicy:     l.OriginalPolicy,
+		Disposition:        l.Disposition,
+		SourceFile:         l.SourceFile,
+		LineNumber:         l.LineNumber,
+		ColumnNumber:       l.ColumnNumber,
+		StatusCode:         l.StatusCode,
+	}}, nil
+}
+
+// WithCSPReportEndpoint registers a handler at path logging CSP violation
+// reports and counting them in httpsrvr_csp_violations_total.
+func (s *httpServer) WithCSPReportEndpoint(path string) *httpServer {
+
+	s.Register(path, CSPReportHandler(func(r *http.Request, v CSPViolation) {
+		directive := v.EffectiveDirective
+		if !cspDirectives[directive] {
+			// reports are untrusted, keep the label values bounded
+			directive = "other"
+		}
+		s.metrics.Counter("httpsrvr_csp_violations_total"+Labels("directive", directive), "Number of reported CSP violations.").Inc()
+		s.log.Info("CSP violation on %s: %s blocked %s (%s:%d)", v.DocumentURI, v.EffectiveDirective, v.BlockedURI, v.SourceFile, v.LineNumber)
+	})).Name("csp-report")
+	return s
+}
@@ -0,0 +1,51 @@
+package httpsrvr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCSPNonceWithoutServer(t *testing.T) {
+
+	headers := NewSecurityHeaders().CSP(NewCSP().Nonce())
+	var nonce string
+	h := headers.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = Nonce(r)
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	policy := w.Header().Get("Content-Security-Policy")
+	if nonce == "" {
+		t.Fatalf("no nonce for the handler, policy %q", policy)
+	}
+	for _, directive := range []string{"script-src", "style-src"} {
+		if !strings.Contains(policy, directive+" 'self' 'nonce-"+nonce+"'") {
+			t.Errorf("%s without nonce %q: %q", directive, nonce, policy)
+		}
+	}
+
+	first := nonce
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if nonce == first {
+		t.Error("nonce reused")
+	}
+}
+
+func TestSecurityHeadersHSTS(t *testing.T) {
+
+	h := NewSecurityHeaders().Middleware(http.NotFoundHandler())
+	for url, want := range map[string]bool{"http://example.com/": false, "https://example.com/": true} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if got := w.Header().Get("Strict-Transport-Security") != ""; got != want {
+			t.Errorf("%s: HSTS sent %v, want %v", url, got, want)
+		}
+		if w.Header().Get("X-Content-Type-Options") != "nosniff" || w.Header().Get("X-Frame-Options") != "DENY" {
+			t.Errorf("%s: missing default headers %v", url, w.Header())
+		}
+	}
+}
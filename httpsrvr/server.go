@@ -2,10 +2,13 @@ package httpsrvr
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -15,9 +18,6 @@ import (
 	"golang.org/x/time/rate"
 )
 
-// only available on linux, see systemd.go
-var listenAndServeSystemD func(*http.Server) error
-
 func NewServer(port int, debug bool) *httpServer {
 
 	start := time.Now()
@@ -28,29 +28,56 @@ func NewServer(port int, debug bool) *httpServer {
 	}
 	host := ""
 	return &httpServer{
-		addr:   fmt.Sprintf("%s:%d", host, port),
-		routes: NewDispatcher(nil, "root"),
+		addr:      fmt.Sprintf("%s:%d", host, port),
+		routes:    NewDispatcher(nil, "root"),
+		hosts:     make(map[string]*dispatcher),
+		wildcards: make(map[string]*dispatcher),
 		// systemd:   systemd,
-		debug:     debug,
-		log:       log.NewStdoutLogger(loglevel),
-		logger:    log.AccessLogger{"CombineLoggerType"}, // log.NewStdoutLogger(loglevel),
-		startedAt: start,
-		instance:  start.Format("20060102T150405"),
+		debug:          debug,
+		log:            log.NewStdoutLogger(loglevel),
+		logger:         log.AccessLogger{Format: "CombineLoggerType"}, // log.NewStdoutLogger(loglevel),
+		startedAt:      start,
+		instance:       start.Format("20060102T150405"),
+		handover:       make(chan struct{}),
+		health:         newHealth(),
+		metrics:        newMetrics(),
+		upgradeSignals: defaultUpgradeSignals,
 	}
 }
 
 type httpServer struct {
-	server    *http.Server
-	routes    *dispatcher
-	log       logger
-	logger    accesslogger
-	addr      string
-	debug     bool
-	systemd   bool
-	limiter   *rate.Limiter
-	instance  string
-	counter   uint64
-	startedAt time.Time
+	server         *http.Server
+	routes         *dispatcher
+	hosts          map[string]*dispatcher // virtual hosts by name
+	wildcards      map[string]*dispatcher // virtual hosts by domain of "*.domain" with leading dot
+	use            []Middleware
+	log            logger
+	logger         accesslogger
+	addr           string
+	debug          bool
+	systemd        bool
+	limiter        *rate.Limiter
+	devProxy       http.Handler
+	certs          *certStore
+	redirect       *http.Server
+	redirectAddr   string // plain HTTP listener redirecting to HTTPS
+	listeners      []*listener
+	socketTLS      map[string]*tls.Config // TLS configuration of named systemd sockets
+	proxies        []*net.IPNet           // trusted to set X-Forwarded-Proto
+	liveness       func() error
+	inflight       int64
+	stopping       int32 // set after the graceful shutdown, stops watchdog pings
+	bound          []*listener
+	handover       chan struct{} // closed when a new process took over the listeners
+	upgradeSignals []os.Signal
+	health         *Health
+	metrics        *Metrics
+	tracer         *tracer
+	panicSink      PanicSink
+	drainDelay     time.Duration
+	instance       string
+	counter        uint64
+	startedAt      time.Time
 }
 
 // NewLimiter returns a new Limiter that allows events up to rate r and permits bursts of at most b tokens.
@@ -83,11 +110,12 @@ func (s *httpServer) Run() {
 
 	s.server = &http.Server{
 		Addr:           s.addr,
-		Handler:        limit(s, s.limiter),
+		Handler:        limit(s, s.limiter, s.rateLimitRejected()),
 		ReadTimeout:    10 * time.Second,
 		WriteTimeout:   10 * time.Second,
 		IdleTimeout:    15 * time.Second, // TODO: was ist das?
 		MaxHeaderBytes: 1 << 20,
+		TLSConfig:      s.tlsConfig(),
 	}
 
 	// for shutdown waiter to signal completed shutdown
@@ -95,23 +123,57 @@ func (s *httpServer) Run() {
 
 	go s.shutdownWaiter(waitForGracefulShutdownComplete)
 
-	var err error
-	if listenAndServeSystemD != nil && s.systemd {
-		s.log.Info("+++ Starting systemd http server +++")
-		err = listenAndServeSystemD(s.server)
-	} else {
-		s.log.Info("+++ Starting http server on %v +++", s.server.Addr)
-		err = s.server.ListenAndServe()
+	certWatcherDone := make(chan struct{})
+	defer close(certWatcherDone)
+	go s.watchCertificates(certWatcherDone)
+
+	s.metrics.Gauge("httpsrvr_requests_in_flight", "Number of requests currently being served.", func() float64 {
+		return float64(atomic.LoadInt64(&s.inflight))
+	})
+
+	if s.debug {
+		s.logRoutes()
+	}
+
+	listeners, err := s.bindListeners()
+	if err != nil {
+		s.log.Fatal(err, "Could not listen")
+	}
+	s.bound = listeners
+
+	// redirects point at the port of the bound TLS listeners
+	if s.redirect = s.redirectServer(listeners); s.redirect != nil {
+		go func() {
+			s.log.Info("+++ Redirecting http on %v to https +++", s.redirect.Addr)
+			if err := s.redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				s.log.Fatal(err, "Could not listen on %s", s.redirect.Addr)
+			}
+		}()
 	}
-	if err != nil && err != http.ErrServerClosed {
-		// immediately returns after shutdown
-		s.log.Fatal(err, "Could not listen on %s", s.addr)
+
+	// Die Notification für Systemd
+	// soll bewusst vor "Serve" stehen!
+	// siehe https://vincent.bernat.ch/en/blog/2017-systemd-golang
+	// und https://vincent.bernat.ch/en/blog/2018-systemd-golang-socket-activation
+	s.notify("READY=1")
+	s.notify(s.status())
+	signalUpgradeReady()
+
+	supervisorDone := make(chan struct{})
+	defer close(supervisorDone)
+	go s.superviseSystemd(supervisorDone)
+	go s.signalWaiter(supervisorDone)
+
+	// immediately returns after shutdown
+	if err := s.serve(listeners); err != nil {
+		s.log.Fatal(err, "Could not serve")
 	}
 
 	<-waitForGracefulShutdownComplete
 }
 
-// ShutdownWaiter waits for shutdown signal on channel {quit}.
+// ShutdownWaiter waits for shutdown signal on channel {quit} or the handover
+// of the listeners to a new process after an upgrade.
 // It then shuts down the server waiting 30 seconds for graceful shutdown.
 // After that the waitForGracefulShutdownComplete channel is closed signalling the waiting ListenAndServe routine to end.
 func (s *httpServer) shutdownWaiter(waitForGracefulShutdownComplete chan<- bool) {
@@ -122,7 +184,20 @@ func (s *httpServer) shutdownWaiter(waitForGracefulShutdownComplete chan<- bool)
 	signal.Notify(quit, os.Interrupt, os.Kill, syscall.SIGTERM)
 
 	// Warten auf SIGTERM
-	<-quit
+	select {
+	case <-quit:
+		s.notify("STOPPING=1")
+	case <-s.handover:
+		// the new process is systemd's main process now
+	}
+
+	// watchdog pings continue while draining, the drain delay may well exceed
+	// WatchdogSec
+	s.health.Drain()
+	if s.drainDelay > 0 {
+		s.log.Info(" +++ Server is draining... shutting down in %v", s.drainDelay)
+		time.Sleep(s.drainDelay)
+	}
 
 	s.log.Info(" +++ Server is shutting down... waiting up to 30 secs")
 
@@ -130,76 +205,239 @@ func (s *httpServer) shutdownWaiter(waitForGracefulShutdownComplete chan<- bool)
 	defer cancel()
 
 	s.server.SetKeepAlivesEnabled(false)
+	if s.redirect != nil {
+		s.redirect.Shutdown(ctx)
+	}
 	if err := s.server.Shutdown(ctx); err != nil {
 		s.log.Info("Could not gracefully shutdown the server: %v\n", err)
 	}
+	atomic.StoreInt32(&s.stopping, 1)
+	if s.tracer != nil {
+		s.tracer.shutdown(ctx)
+	}
 	close(waitForGracefulShutdownComplete)
 }
 
-// Register connects given handler to given path prefix
+// Reload reloads changed TLS certificates, notifying systemd before and after.
+// It is triggered by SIGHUP.
+func (s *httpServer) Reload() error {
+
+	s.log.Info(" +++ Reloading server")
+	s.notify("RELOADING=1")
+	defer s.notify("READY=1")
+
+	return s.ReloadCertificates()
+}
+
+// signalWaiter calls Upgrade on the upgrade signals and Reload on SIGHUP
+// until done is closed.
+func (s *httpServer) signalWaiter(done <-chan struct{}) {
+
+	upgrade := make(chan os.Signal, 1)
+	if len(s.upgradeSignals) > 0 {
+		signal.Notify(upgrade, s.upgradeSignals...)
+		defer signal.Stop(upgrade)
+	}
+	hup := make(chan os.Signal, 1)
+	if !containsSignal(s.upgradeSignals, syscall.SIGHUP) {
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+	}
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			if err := s.Reload(); err != nil {
+				s.log.Info("Could not reload: %v", err)
+			}
+		case <-upgrade:
+			if err := s.Upgrade(); err != nil {
+				s.log.Info("Could not upgrade: %v", err)
+				continue
+			}
+			return
+		}
+	}
+}
+
+func containsSignal(signals []os.Signal, sig os.Signal) bool {
+	for _, s := range signals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
+
+// Register connects given handler to given path prefix.
+// A nil handler only creates the route for binding method handlers:
+//
+//	s.Register("/login", nil).Get(loginPage).Post(login)
 func (s *httpServer) Register(path string, handler interface{}) *dispatcher {
 
+	h, ok := toHandler(handler)
+	if !ok {
+		s.log.Info("Could not register route '%v': unknown handler type %T", path, handler)
+		os.Exit(1)
+	}
+	return s.routes.Register(path, h)
+}
+
+// Use adds middleware applying to all routes of the server including those
+// of virtual hosts, see dispatcher.Use.
+func (s *httpServer) Use(middleware ...Middleware) *httpServer {
+
+	s.use = append(s.use, middleware...)
+	s.routes.Use(middleware...)
+	for _, d := range s.hostRoutes() {
+		d.Use(middleware...)
+	}
+	return s
+}
+
+// toHandler converts the handler types accepted by Register to http.Handler.
+// Nil functions are returned as nil.
+func toHandler(handler interface{}) (http.Handler, bool) {
+
 	switch h := handler.(type) {
+	case nil:
+		return nil, true
+
 	case http.Handler:
-		return s.routes.Register(path, h)
+		return h, true
 
 	case func(w http.ResponseWriter, r *http.Request):
-		return s.routes.Register(path, http.HandlerFunc(h))
-
-	case ErrorHandler:
-		return s.routes.Register(path, h)
+		if h == nil {
+			return nil, true
+		}
+		return http.HandlerFunc(h), true
 
 	case func(http.ResponseWriter, *http.Request) error:
-		return s.routes.Register(path, ErrorHandler(h))
-
-	default:
-		s.log.Info("Could not register route '%v': unknown handler type %T", path, handler)
-		os.Exit(1)
+		if h == nil {
+			return nil, true
+		}
+		return ErrorHandler(h), true
 	}
-	return nil
+	return nil, false
 }
 
 func (s *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
-	start := time.Now()
-	reqnum := atomic.AddUint64(&s.counter, 1)
-	reqid := r.Header.Get("X-Request-ID")
-	if reqid == "" {
-		reqid = fmt.Sprintf("%s-%d", s.instance, reqnum)
+	info := &requestInfo{}
+
+	atomic.AddInt64(&s.inflight, 1)
+	defer atomic.AddInt64(&s.inflight, -1)
+
+	info.start = time.Now()
+	info.counter = atomic.AddUint64(&s.counter, 1)
+	info.id = r.Header.Get("X-Request-ID")
+	if info.id == "" {
+		info.id = fmt.Sprintf("%s-%d", s.instance, info.counter)
 	}
+	info.debug = s.debug
+	info.https = r.TLS != nil || s.forwardedHTTPS(r)
 
 	rw := NewResponseWriter(w)
 
-	ctx := context.WithValue(r.Context(), "reqid", reqid)
-	ctx = context.WithValue(ctx, "counter", reqnum)
-	ctx = context.WithValue(ctx, "debug", s.debug)
+	r = r.WithContext(context.WithValue(r.Context(), infoKey, info))
 
-	r = r.WithContext(ctx)
+	var routes *dispatcher
+	routes, info.host, info.subdomain = s.matchHost(r.Host)
+	dispatcher, tail, params := routes.match(r.URL.Path)
+	info.params = params
+
+	var span *Span
+	if s.tracer != nil {
+		span = s.tracer.start(r, dispatcher.name)
+		span.SetAttribute("http.request_id", info.id)
+		r = r.WithContext(ContextWithSpan(r.Context(), span))
+	}
 
-	dispatcher, tail := s.Dispatch(r.URL.Path)
 	if !dispatcher.preserve {
 		r.URL.Path = tail
 	}
+	info.name = dispatcher.name
+	info.route = dispatcher.pattern
+
+	defer func() {
+		abort := false
+		if v := recover(); v != nil {
+			abort = s.recovered(rw, r, v, info.debug)
+			if span != nil {
+				span.SetError(fmt.Errorf("panic: %v", v))
+			}
+			color.Red(" error request %d: %s %s%s => %d (%d bytes, %v)\n", info.counter, info.id, r.Host, r.URL.Path, rw.statusCode, rw.Count(), time.Since(info.start))
+		}
+		if abort {
+			// net/http closes the connection without logging
+			defer panic(http.ErrAbortHandler)
+		}
 
-	defer func(start time.Time, reqnum uint64, reqid string, name string) {
-		err := recover()
-		if err != nil {
-			color.Red(" error request %d: %s %s => %d (%d bytes, %v)\n", reqnum, reqid, r.URL.Path, rw.statusCode, rw.Count(), time.Since(start))
+		if span != nil {
+			span.SetAttribute("http.status_code", strconv.Itoa(rw.statusCode))
+			if rw.statusCode >= 500 && span.Status == StatusUnset {
+				span.Status = StatusError
+			}
+			span.End()
+		}
+		s.metrics.observe(info.name, r.Method, rw.statusCode, int(rw.Count()), time.Since(info.start))
+		user := info.username()
+		if user == "" {
+			user = "-"
+		}
+		uncompressed := rw.Uncompressed()
+		if uncompressed == rw.Count() {
+			// only logged for compressed responses
+			uncompressed = 0
 		}
+		s.access(log.AccessEntry{
+			ReqNum:       info.counter,
+			ReqID:        info.id,
+			Start:        info.start,
+			RemoteAddr:   r.RemoteAddr,
+			Username:     user,
+			Method:       r.Method,
+			Host:         stripPort(r.Host),
+			URI:          r.URL.Path,
+			Proto:        r.Proto,
+			Status:       rw.statusCode,
+			Size:         int(rw.Count()),
+			Uncompressed: int(uncompressed),
+			Duration:     time.Since(info.start),
+			Referer:      r.Referer(),
+			Agent:        info.name,
+		})
+		if uncompressed != 0 {
+			color.Green("request %d: %s %s%s => %d (%d bytes, %d uncompressed, %v)\n", info.counter, info.id, r.Host, r.URL.Path, rw.statusCode, rw.Count(), uncompressed, time.Since(info.start))
+			return
+		}
+		color.Green("request %d: %s %s%s => %d (%d bytes, %v)\n", info.counter, info.id, r.Host, r.URL.Path, rw.statusCode, rw.Count(), time.Since(info.start))
+	}()
+
+	if s.devProxy != nil && dispatcher.unmatched() {
+		s.devProxy.ServeHTTP(rw, r)
+		return
+	}
+	dispatcher.ServeHTTP(rw, r)
+}
 
-		s.logger.Access(reqnum, reqid, start, r.RemoteAddr, "user", r.Method, r.URL.Path, r.Proto, rw.statusCode, int(rw.Count()), time.Since(start), r.Referer(), name)
-		color.Green("request %d: %s %s => %d (%d bytes, %v)\n", reqnum, reqid, r.URL.Path, rw.statusCode, rw.Count(), time.Since(start))
-	}(start, reqnum, reqid, dispatcher.name)
+// access writes e to the access log, without host if the logger only
+// implements Access.
+func (s *httpServer) access(e log.AccessEntry) {
 
-	dispatcher.handler.ServeHTTP(rw, r)
+	if l, ok := s.logger.(entryLogger); ok {
+		l.Log(e)
+		return
+	}
+	s.logger.Access(e.ReqNum, e.ReqID, e.Start, e.RemoteAddr, e.Username, e.Method, e.URI, e.Proto, e.Status, e.Size, e.Duration, e.Referer, e.Agent)
 }
 
+// Dispatch returns the dispatcher of the default routes for route and the
+// unmatched remainder of route.
 func (s *httpServer) Dispatch(route string) (*dispatcher, string) {
 
-	head, tail := shiftPath(route)
-
-	if disp, ok := s.routes.children[head]; ok {
-		return disp.GetDispatcher(tail)
-	}
-	return s.routes, route
+	d, tail, _ := s.routes.match(route)
+	return d, tail
 }
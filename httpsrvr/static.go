@@ -0,0 +1,248 @@
+package httpsrvr
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+// Static returns a handler serving the files of fsys, e.g. an embed.FS or os.DirFS.
+// The request path relative to the route is looked up in fsys. Responses carry
+// strong ETags and support Range requests. If the client accepts it, a
+// precompressed sibling file with .br or .gz extension is served instead.
+func Static(fsys fs.FS) *StaticHandler {
+	return &StaticHandler{fsys: fsys, index: "index.html"}
+}
+
+// StaticDir returns a handler serving the files below directory dir.
+func StaticDir(dir string) *StaticHandler {
+	return Static(os.DirFS(dir))
+}
+
+type StaticHandler struct {
+	fsys    fs.FS
+	index   string
+	listing bool
+	spa     string
+	cache   []cachePolicy
+	etags   sync.Map // file name => etag
+}
+
+type cachePolicy struct {
+	pattern string
+	value   string
+}
+
+type etag struct {
+	modTime time.Time
+	size    int64
+	value   string
+}
+
+// Cache sets the Cache-Control header of files whose path or base name matches
+// pattern (see path.Match), e.g. Cache("*.js", "public, max-age=31536000, immutable").
+// Policies are tried in the order given, the first matching one wins.
+func (h *StaticHandler) Cache(pattern, value string) *StaticHandler {
+
+	h.cache = append(h.cache, cachePolicy{pattern, value})
+	return h
+}
+
+// Listing enables or disables listings of directories without index file.
+func (h *StaticHandler) Listing(enabled bool) *StaticHandler {
+
+	h.listing = enabled
+	return h
+}
+
+// SPA enables single page application mode: requests for unknown paths without
+// file extension are answered with the given file, usually "index.html", so
+// client side routing works below the route the handler is registered for.
+func (h *StaticHandler) SPA(index string) *StaticHandler {
+
+	h.spa = strings.TrimPrefix(index, "/")
+	return h
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+
+	err := h.serve(w, r, name)
+	if errors.Is(err, fs.ErrNotExist) && h.spa != "" && path.Ext(name) == "" {
+		err = h.serve(w, r, h.spa)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (h *StaticHandler) serve(w http.ResponseWriter, r *http.Request, name string) error {
+
+	info, err := fs.Stat(h.fsys, name)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if !strings.HasSuffix(r.URL.Path, "/") && name != "." {
+			// relative redirect, the route prefix is not known here
+			w.Header().Set("Location", path.Base(name)+"/")
+			w.WriteHeader(http.StatusMovedPermanently)
+			return nil
+		}
+		index := path.Join(name, h.index)
+		if _, err := fs.Stat(h.fsys, index); err == nil {
+			return h.serveFile(w, r, index)
+		}
+		if !h.listing {
+			return fs.ErrNotExist
+		}
+		return h.serveListing(w, name)
+	}
+	return h.serveFile(w, r, name)
+}
+
+func (h *StaticHandler) serveFile(w http.ResponseWriter, r *http.Request, name string) error {
+
+	for _, policy := range h.cache {
+		if ok, _ := path.Match(policy.pattern, name); ok {
+			w.Header().Set("Cache-Control", policy.value)
+			break
+		}
+		if ok, _ := path.Match(policy.pattern, path.Base(name)); ok {
+			w.Header().Set("Cache-Control", policy.value)
+			break
+		}
+	}
+
+	file, encoding := name, ""
+	var available []string
+	for _, enc := range []string{"br", "gzip"} {
+		if _, err := fs.Stat(h.fsys, name+precompressed[enc]); err == nil {
+			available = append(available, enc)
+		}
+	}
+	if len(available) > 0 {
+		addVary(w.Header(), "Accept-Encoding")
+		if accepted := r.Header.Get("Accept-Encoding"); accepted != "" {
+			if encoding = negotiate(accepted, available...); encoding != "" {
+				file = name + precompressed[encoding]
+			}
+		}
+	}
+
+	f, err := h.fsys.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(b)
+	}
+
+	tag, err := h.etag(file, info, content)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", tag)
+	if encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+
+	// the content type is derived from the name of the uncompressed file
+	http.ServeContent(w, r, name, info.ModTime(), content)
+	return nil
+}
+
+// etag returns the strong ETag of file computed from its content. It is cached
+// as long as modification time and size of the file do not change.
+func (h *StaticHandler) etag(file string, info fs.FileInfo, content io.ReadSeeker) (string, error) {
+
+	if cached, ok := h.etags.Load(file); ok {
+		e := cached.(etag)
+		if e.modTime.Equal(info.ModTime()) && e.size == info.Size() {
+			return e.value, nil
+		}
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	value := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	h.etags.Store(file, etag{info.ModTime(), info.Size(), value})
+	return value, nil
+}
+
+func (h *StaticHandler) serveListing(w http.ResponseWriter, name string) error {
+
+	entries, err := fs.ReadDir(h.fsys, name)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return listingTemplate.Execute(w, struct {
+		Name    string
+		Entries []fs.DirEntry
+	}{"/" + strings.TrimPrefix(name, "."), entries})
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><title>{{.Name}}</title></head>
+<body>
+<h1>{{.Name}}</h1>
+<ul>
+<li><a href="../">../</a></li>
+{{range .Entries}}<li><a href="{{.Name}}{{if .IsDir}}/{{end}}">{{.Name}}{{if .IsDir}}/{{end}}</a></li>
+{{end}}</ul>
+</body>
+</html>
+`))
+
+// precompressed maps encodings to the extensions of precompressed siblings.
+var precompressed = map[string]string{"br": ".br", "gzip": ".gz"}
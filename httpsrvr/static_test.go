@@ -0,0 +1,106 @@
+package httpsrvr
+
+import (
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func staticFS() fstest.MapFS {
+	return fstest.MapFS{
+		"index.html":       {Data: []byte("index")},
+		"app.js":           {Data: []byte("plain js")},
+		"app.js.br":        {Data: []byte("br js")},
+		"app.js.gz":        {Data: []byte("gzip js")},
+		"style.css":        {Data: []byte("plain css")},
+		"style.css.gz":     {Data: []byte("gzip css")},
+		"docs/index.html":  {Data: []byte("docs")},
+		"docs/guide.html":  {Data: []byte("guide")},
+		"assets/logo.svg":  {Data: []byte("<svg/>")},
+		"assets/empty/.ok": {Data: []byte("")},
+	}
+}
+
+func TestStaticSPA(t *testing.T) {
+
+	tests := []struct {
+		path   string
+		spa    bool
+		status int
+		body   string
+	}{
+		{"/", false, 200, "index"},
+		{"/docs/", false, 200, "docs"},
+		{"/docs", false, 301, ""},
+		{"/docs/guide.html", false, 200, "guide"},
+		{"/users/42", false, 404, "Not Found\n"},
+		{"/users/42", true, 200, "index"},
+		{"/deep/client/route", true, 200, "index"},
+		{"/missing.js", true, 404, "Not Found\n"},
+		{"/assets/missing.png", true, 404, "Not Found\n"},
+		{"/assets/empty/", true, 200, "index"},
+		{"/docs/guide.html", true, 200, "guide"},
+	}
+	for _, test := range tests {
+		h := Static(staticFS())
+		if test.spa {
+			h.SPA("/index.html")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.status || test.body != "" && w.Body.String() != test.body {
+			t.Errorf("%s (spa %v): got %d %q, want %d %q", test.path, test.spa, w.Code, w.Body, test.status, test.body)
+		}
+	}
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+
+	tests := []struct {
+		path, acceptEncoding string
+		encoding, body       string
+		vary                 bool
+	}{
+		{"/app.js", "", "", "plain js", true},
+		{"/app.js", "gzip, deflate, br", "br", "br js", true},
+		{"/app.js", "gzip", "gzip", "gzip js", true},
+		{"/app.js", "br;q=0.5, gzip", "gzip", "gzip js", true},
+		{"/app.js", "br;q=0, *", "gzip", "gzip js", true},
+		{"/app.js", "identity", "", "plain js", true},
+		{"/style.css", "br", "", "plain css", true},
+		{"/style.css", "br, gzip", "gzip", "gzip css", true},
+		{"/index.html", "br, gzip", "", "index", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		Static(staticFS()).ServeHTTP(w, r)
+		if got := w.Header().Get("Content-Encoding"); got != test.encoding || w.Body.String() != test.body {
+			t.Errorf("%s %q: got %q %q, want %q %q", test.path, test.acceptEncoding, got, w.Body, test.encoding, test.body)
+		}
+		if vary := w.Header().Get("Vary") == "Accept-Encoding"; vary != test.vary {
+			t.Errorf("%s %q: Vary %q", test.path, test.acceptEncoding, w.Header().Get("Vary"))
+		}
+	}
+}
+
+func TestStaticPrecompressedContentType(t *testing.T) {
+
+	r := httptest.NewRequest("GET", "/app.js", nil)
+	r.Header.Set("Accept-Encoding", "br")
+	w := httptest.NewRecorder()
+	Static(staticFS()).ServeHTTP(w, r)
+	if ct := w.Header().Get("Content-Type"); ct != "text/javascript; charset=utf-8" && ct != "application/javascript" {
+		t.Errorf("got Content-Type %q", ct)
+	}
+
+	// the ETag of the compressed representation differs from the plain one
+	plain := httptest.NewRecorder()
+	Static(staticFS()).ServeHTTP(plain, httptest.NewRequest("GET", "/app.js", nil))
+	if w.Header().Get("ETag") == plain.Header().Get("ETag") {
+		t.Errorf("same ETag %s for br and plain", w.Header().Get("ETag"))
+	}
+}
@@ -1,10 +1,11 @@
+//go:build linux
 // +build linux
 
 package httpsrvr
 
 import (
-	"log"
-	"net/http"
+	"net"
+	"time"
 
 	"github.com/coreos/go-systemd/activation"
 	"github.com/coreos/go-systemd/daemon"
@@ -13,24 +14,24 @@ import (
 
 func init() {
 
-	listenAndServeSystemD = func(s *http.Server) error {
-		log.Println("Serving on Linux with SystemD ...")
+	activatedListeners = func() (map[string][]net.Listener, error) {
 
-		listeners, err := activation.Listeners()
+		listeners, err := activation.ListenersWithNames()
 		if err != nil {
-			return errors.Wrap(err, "cannot retrieve systemd listeners")
-		}
-
-		if len(listeners) == 0 {
-			return errors.New("cannot retrieve systemd listeners")
+			return nil, errors.Wrap(err, "cannot retrieve systemd listeners")
 		}
+		return listeners, nil
+	}
 
-		// Die Notification für Systemd
-		// soll bewusst vor "Serve" stehen!
-		// siehe https://vincent.bernat.ch/en/blog/2017-systemd-golang
-		// und https://vincent.bernat.ch/en/blog/2018-systemd-golang-socket-activation
-		daemon.SdNotify(false, "READY=1")
+	sdNotify = func(state string) {
+		daemon.SdNotify(false, state)
+	}
 
-		return s.Serve(listeners[0])
+	sdWatchdogInterval = func() time.Duration {
+		interval, err := daemon.SdWatchdogEnabled(false)
+		if err != nil {
+			return 0
+		}
+		return interval
 	}
 }
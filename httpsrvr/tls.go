@@ -0,0 +1,254 @@
+package httpsrvr
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+// certReloadInterval is how often certificate files are checked for changes.
+var certReloadInterval = 30 * time.Second
+
+// WithTLS serves HTTPS with the certificate and key from the given PEM files.
+// It may be called repeatedly; the certificate is then chosen by SNI, the
+// first one being the default. Changed files are reloaded automatically.
+func (s *httpServer) WithTLS(certFile, keyFile string) *httpServer {
+
+	if s.certs == nil {
+		s.certs = &certStore{}
+	}
+	if err := s.certs.add(certFile, keyFile); err != nil {
+		s.log.Fatal(err, "Could not load TLS certificate %s", certFile)
+	}
+	return s
+}
+
+// RedirectHTTP additionally listens for plain HTTP on port and redirects all
+// requests to HTTPS. It has no effect without WithTLS.
+func (s *httpServer) RedirectHTTP(port int) *httpServer {
+
+	s.redirectAddr = fmt.Sprintf(":%d", port)
+	return s
+}
+
+// TrustProxies trusts the X-Forwarded-Proto header of requests from the given
+// networks in CIDR notation or single IPs, e.g. a TLS terminating load
+// balancer. Clients connecting directly can't claim HTTPS that way. It panics
+// on invalid input.
+func (s *httpServer) TrustProxies(networks ...string) *httpServer {
+
+	s.proxies = append(s.proxies, parseNetworks("proxy", networks)...)
+	return s
+}
+
+// IsHTTPS reports whether the client of r connected via HTTPS, directly or
+// through a proxy trusted with TrustProxies. Outside the server only direct
+// TLS connections count.
+func IsHTTPS(r *http.Request) bool {
+
+	if r.TLS != nil {
+		return true
+	}
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.https
+	}
+	return false
+}
+
+// forwardedHTTPS reports whether r was forwarded via HTTPS by a trusted proxy.
+func (s *httpServer) forwardedHTTPS(r *http.Request) bool {
+	return r.Header.Get("X-Forwarded-Proto") == "https" && containsIP(s.proxies, clientIP(r))
+}
+
+// tlsConfig returns the TLS configuration with modern defaults or nil if TLS is not configured.
+func (s *httpServer) tlsConfig() *tls.Config {
+
+	if s.certs == nil {
+		return nil
+	}
+	return &tls.Config{
+		GetCertificate:   s.certs.GetCertificate,
+		MinVersion:       tls.VersionTLS12,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// redirectServer returns the server redirecting plain HTTP requests to HTTPS
+// on the port of the first TCP listener serving TLS, or nil.
+func (s *httpServer) redirectServer(listeners []*listener) *http.Server {
+
+	if s.certs == nil || s.redirectAddr == "" {
+		return nil
+	}
+
+	_, port, _ := net.SplitHostPort(s.addr)
+	for _, l := range listeners {
+		if addr, ok := l.ln.Addr().(*net.TCPAddr); ok && l.tls != nil {
+			port = strconv.Itoa(addr.Port)
+			break
+		}
+	}
+	if port == "443" {
+		port = ""
+	}
+
+	return &http.Server{
+		Addr: s.redirectAddr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host := stripPort(r.Host)
+			if port != "" {
+				host = net.JoinHostPort(host, port)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}),
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
+	}
+}
+
+// watchCertificates reloads changed certificates until done is closed.
+func (s *httpServer) watchCertificates(done <-chan struct{}) {
+
+	if s.certs == nil {
+		return
+	}
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := s.ReloadCertificates(); err != nil {
+				s.log.Info("Could not reload TLS certificates: %v", err)
+			}
+		}
+	}
+}
+
+// ReloadCertificates reloads all certificates whose files changed on disk.
+// Certificates failing to load keep being served in their previous version.
+func (s *httpServer) ReloadCertificates() error {
+
+	if s.certs == nil {
+		return nil
+	}
+	reloaded, err := s.certs.reload()
+	for _, file := range reloaded {
+		s.log.Info("Reloaded TLS certificate %s", file)
+	}
+	return err
+}
+
+// certStore holds the certificates of a server and selects them by SNI.
+type certStore struct {
+	mu    sync.RWMutex
+	certs []*certificate
+}
+
+type certificate struct {
+	certFile, keyFile string
+	modified          string // modification times and sizes of both files
+	cert              *tls.Certificate
+}
+
+func (cs *certStore) add(certFile, keyFile string) error {
+
+	c := &certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.load(); err != nil {
+		return err
+	}
+	cs.mu.Lock()
+	cs.certs = append(cs.certs, c)
+	cs.mu.Unlock()
+	return nil
+}
+
+func (cs *certStore) reload() ([]string, error) {
+
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	var reloaded []string
+	var failed error
+	for _, c := range cs.certs {
+		modified, err := c.stat()
+		if err != nil {
+			failed = errors.Wrap(err, "cannot stat %s", c.certFile)
+			continue
+		}
+		if modified == c.modified {
+			continue
+		}
+		if err := c.load(); err != nil {
+			failed = err
+			continue
+		}
+		reloaded = append(reloaded, c.certFile)
+	}
+	return reloaded, failed
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (cs *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+
+	if len(cs.certs) == 0 {
+		return nil, errors.New("no TLS certificate")
+	}
+	for _, c := range cs.certs {
+		if hello.SupportsCertificate(c.cert) == nil {
+			return c.cert, nil
+		}
+	}
+	return cs.certs[0].cert, nil
+}
+
+func (c *certificate) stat() (string, error) {
+
+	modified := ""
+	for _, file := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		modified += info.ModTime().String() + "/" + strconv.FormatInt(info.Size(), 10) + ";"
+	}
+	return modified, nil
+}
+
+func (c *certificate) load() error {
+
+	modified, err := c.stat()
+	if err != nil {
+		return errors.Wrap(err, "cannot stat certificate files")
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return errors.Wrap(err, "cannot load key pair %s, %s", c.certFile, c.keyFile)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return errors.Wrap(err, "cannot parse certificate %s", c.certFile)
+	}
+	c.cert, c.modified = &cert, modified
+	return nil
+}
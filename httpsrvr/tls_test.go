@@ -0,0 +1,216 @@
+package httpsrvr
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrustProxies(t *testing.T) {
+
+	s := NewServer(0, false).TrustProxies("10.0.0.0/8", "192.0.2.7")
+	var https bool
+	s.Register("/", func(w http.ResponseWriter, r *http.Request) { https = IsHTTPS(r) })
+
+	tests := []struct {
+		remote, proto string
+		want          bool
+	}{
+		{"10.1.2.3:5000", "https", true},
+		{"192.0.2.7:5000", "https", true},
+		{"10.1.2.3:5000", "http", false},
+		{"10.1.2.3:5000", "", false},
+		// direct clients can't claim HTTPS
+		{"192.0.2.1:5000", "https", false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		if https != test.want {
+			t.Errorf("%s %q: got %v, want %v", test.remote, test.proto, https, test.want)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	if !IsHTTPS(r) {
+		t.Error("TLS request outside the server not detected")
+	}
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if IsHTTPS(r) {
+		t.Error("X-Forwarded-Proto trusted outside the server")
+	}
+}
+
+// writeCert writes a self-signed certificate for names and its key to dir.
+func writeCert(t *testing.T, dir, file string, names ...string) (string, string) {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, file+".crt"), filepath.Join(dir, file+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// handshake returns the certificate the server presents for serverName.
+func handshake(t *testing.T, config *tls.Config, serverName string) *x509.Certificate {
+
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		tls.Server(serverConn, config).Handshake()
+		serverConn.Close()
+	}()
+	client := tls.Client(clientConn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	if err := client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	return client.ConnectionState().PeerCertificates[0]
+}
+
+func TestCertificateSelection(t *testing.T) {
+
+	dir := t.TempDir()
+	s := NewServer(0, false).
+		WithTLS(writeCert(t, dir, "default", "example.com")).
+		WithTLS(writeCert(t, dir, "blog", "blog.example.org", "*.blog.example.org"))
+	config := s.TLSConfig()
+
+	tests := []struct{ serverName, want string }{
+		{"example.com", "example.com"},
+		{"blog.example.org", "blog.example.org"},
+		{"jane.blog.example.org", "blog.example.org"},
+		{"unknown.net", "example.com"},
+		{"", "example.com"},
+	}
+	for _, test := range tests {
+		if got := handshake(t, config, test.serverName).Subject.CommonName; got != test.want {
+			t.Errorf("%q: got %s, want %s", test.serverName, got, test.want)
+		}
+	}
+}
+
+func TestReloadCertificates(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "site", "old.example.com")
+	s := NewServer(0, false).WithTLS(certFile, keyFile)
+	s.log = &recordingLogger{}
+	config := s.TLSConfig()
+
+	// unchanged files are not reloaded
+	if err := s.ReloadCertificates(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeCert(t, dir, "site", "new.example.com")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if err := s.ReloadCertificates(); err != nil {
+		t.Fatal(err)
+	}
+	if got := handshake(t, config, "").Subject.CommonName; got != "new.example.com" {
+		t.Errorf("got %s after reload", got)
+	}
+
+	// a broken file keeps the previous certificate
+	os.WriteFile(certFile, []byte("garbage"), 0600)
+	if err := s.ReloadCertificates(); err == nil {
+		t.Error("no error reloading a broken certificate")
+	}
+	if got := handshake(t, config, "").Subject.CommonName; got != "new.example.com" {
+		t.Errorf("got %s after failed reload", got)
+	}
+}
+
+func TestRedirectHTTP(t *testing.T) {
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "site", "example.com")
+
+	tlsListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tlsListener.Close()
+	_, tlsPort, _ := net.SplitHostPort(tlsListener.Addr().String())
+	plain, err := net.Listen("unix", filepath.Join(dir, "plain.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+
+	tests := []struct {
+		name      string
+		addr      string
+		listeners []*listener
+		location  string
+	}{
+		{"no listeners", ":8443", nil, "https://example.com:8443/a?b=c"},
+		{"standard port", ":443", nil, "https://example.com/a?b=c"},
+		{"bound tls listener", ":8443", []*listener{
+			{network: "unix", ln: plain},
+			{network: "tcp", ln: tlsListener, tls: &tls.Config{}},
+		}, "https://example.com:" + tlsPort + "/a?b=c"},
+		{"no tls listener", ":8443", []*listener{{network: "tcp", ln: tlsListener}}, "https://example.com:8443/a?b=c"},
+	}
+	for _, test := range tests {
+		s := NewServer(0, false).WithTLS(certFile, keyFile).RedirectHTTP(8080)
+		s.addr = test.addr
+		redirect := s.redirectServer(test.listeners)
+		r := httptest.NewRequest("GET", "http://example.com:8080/a?b=c", nil)
+		w := httptest.NewRecorder()
+		redirect.Handler.ServeHTTP(w, r)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != test.location {
+			t.Errorf("%s: got %d %q, want %q", test.name, w.Code, w.Header().Get("Location"), test.location)
+		}
+	}
+
+	if NewServer(0, false).RedirectHTTP(8080).redirectServer(nil) != nil {
+		t.Error("redirect without TLS")
+	}
+}
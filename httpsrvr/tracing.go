@@ -0,0 +1,331 @@
+package httpsrvr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID identifies a trace, see https://www.w3.org/TR/trace-context/.
+type TraceID [16]byte
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+// IsValid reports whether id is not all zeros.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid reports whether id is not all zeros.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+func (id TraceID) MarshalText() ([]byte, error) { return []byte(id.String()), nil }
+func (id SpanID) MarshalText() ([]byte, error)  { return []byte(id.String()), nil }
+
+// SpanKind is the role of a span, numbered as in OpenTelemetry.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = 1
+	SpanKindServer   SpanKind = 2
+	SpanKindClient   SpanKind = 3
+)
+
+// SpanStatus is the outcome of a span, numbered as in OpenTelemetry.
+type SpanStatus int
+
+const (
+	StatusUnset SpanStatus = 0
+	StatusOK    SpanStatus = 1
+	StatusError SpanStatus = 2
+)
+
+// Span is a timed operation within a trace. The server starts one span per
+// request named after the matched dispatcher. A span must not be modified
+// concurrently.
+type Span struct {
+	Name          string            `json:"name"`
+	Kind          SpanKind          `json:"kind"`
+	TraceID       TraceID           `json:"traceId"`
+	SpanID        SpanID            `json:"spanId"`
+	ParentID      SpanID            `json:"parentSpanId"`
+	Sampled       bool              `json:"sampled"`
+	TraceState    string            `json:"traceState,omitempty"`
+	StartTime     time.Time         `json:"startTime"`
+	EndTime       time.Time         `json:"endTime"`
+	Status        SpanStatus        `json:"status"`
+	StatusMessage string            `json:"statusMessage,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+
+	tracer *tracer
+	ended  bool
+}
+
+// SpanExporter sends finished spans to a tracing backend.
+type SpanExporter interface {
+	Export(ctx context.Context, spans []*Span) error
+}
+
+// WithTracing enables tracing: every request gets a server span joining the
+// trace of an incoming traceparent header. Sampled spans are exported in
+// batches by exporter.
+func (s *httpServer) WithTracing(exporter SpanExporter) *httpServer {
+
+	s.tracer = newTracer(exporter, s.log)
+	return s
+}
+
+// SpanFromContext returns the current span stored in ctx or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// ContextWithSpan returns a copy of ctx with span as current span.
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey, span)
+}
+
+// StartSpan starts a child span of the current span of ctx and returns a
+// context carrying it. Without current span the returned span is not exported.
+//
+//	ctx, span := httpsrvr.StartSpan(r.Context(), "load user")
+//	defer span.End()
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+
+	span := &Span{Name: name, Kind: SpanKindInternal, SpanID: newSpanID(), StartTime: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		span.TraceID, span.ParentID = parent.TraceID, parent.SpanID
+		span.Sampled, span.TraceState = parent.Sampled, parent.TraceState
+		span.tracer = parent.tracer
+	} else {
+		span.TraceID = newTraceID()
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+// InjectTrace sets the traceparent and tracestate headers of an outgoing
+// request, so the called service joins the trace of the current span of ctx.
+//
+//	req, _ := http.NewRequestWithContext(r.Context(), "GET", url, nil)
+//	httpsrvr.InjectTrace(r.Context(), req.Header)
+func InjectTrace(ctx context.Context, header http.Header) {
+
+	span := SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+	header.Set("traceparent", span.Traceparent())
+	if span.TraceState != "" {
+		header.Set("tracestate", span.TraceState)
+	} else {
+		header.Del("tracestate")
+	}
+}
+
+// Traceparent returns the value of the traceparent header naming span as parent.
+func (span *Span) Traceparent() string {
+
+	flags := "00"
+	if span.Sampled {
+		flags = "01"
+	}
+	return "00-" + span.TraceID.String() + "-" + span.SpanID.String() + "-" + flags
+}
+
+// SetAttribute sets an attribute exported with the span.
+func (span *Span) SetAttribute(key, value string) *Span {
+
+	if span.Attributes == nil {
+		span.Attributes = make(map[string]string)
+	}
+	span.Attributes[key] = value
+	return span
+}
+
+// SetError marks the span as failed.
+func (span *Span) SetError(err error) *Span {
+
+	span.Status = StatusError
+	if err != nil {
+		span.StatusMessage = err.Error()
+	}
+	return span
+}
+
+// End finishes the span and queues it for export if it is sampled.
+// Calls after the first one are ignored.
+func (span *Span) End() {
+
+	if span.ended {
+		return
+	}
+	span.ended = true
+	span.EndTime = time.Now()
+	if span.tracer != nil && span.Sampled {
+		span.tracer.queue(span)
+	}
+}
+
+// parseTraceparent parses a traceparent header value. Versions above 00 are
+// accepted as long as their prefix has the format of version 00.
+func parseTraceparent(value string) (trace TraceID, parent SpanID, sampled bool, ok bool) {
+
+	value = strings.TrimSpace(value)
+	if len(value) < 55 || value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return trace, parent, false, false
+	}
+	version, err := strconv.ParseUint(value[:2], 16, 8)
+	if err != nil || version == 0xff || (version == 0 && len(value) != 55) || (len(value) > 55 && value[55] != '-') {
+		return trace, parent, false, false
+	}
+	if !decodeLowerHex(trace[:], value[3:35]) || !decodeLowerHex(parent[:], value[36:52]) {
+		return trace, parent, false, false
+	}
+	flags, err := strconv.ParseUint(value[53:55], 16, 8)
+	if err != nil || !trace.IsValid() || !parent.IsValid() {
+		return trace, parent, false, false
+	}
+	return trace, parent, flags&1 == 1, true
+}
+
+func decodeLowerHex(dst []byte, src string) bool {
+
+	if strings.ToLower(src) != src {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(src))
+	return err == nil
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return id
+}
+
+const (
+	traceBatchSize     = 128
+	traceQueueSize     = 2048
+	traceFlushInterval = 5 * time.Second
+)
+
+// tracer collects finished spans and exports them in batches in the background.
+type tracer struct {
+	exporter SpanExporter
+	log      logger
+	spans    chan *Span
+	done     chan struct{} // closed on shutdown
+	stopped  chan struct{} // closed when the queued spans are exported
+	once     sync.Once
+}
+
+func newTracer(exporter SpanExporter, log logger) *tracer {
+
+	t := &tracer{exporter: exporter, log: log, spans: make(chan *Span, traceQueueSize)}
+	t.done, t.stopped = make(chan struct{}), make(chan struct{})
+	go t.run()
+	return t
+}
+
+// start starts the server span of r. It joins the trace of a valid
+// traceparent header and starts a new sampled trace otherwise.
+func (t *tracer) start(r *http.Request, name string) *Span {
+
+	span := &Span{Name: name, Kind: SpanKindServer, SpanID: newSpanID(), StartTime: time.Now(), tracer: t}
+	if trace, parent, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+		span.TraceID, span.ParentID, span.Sampled = trace, parent, sampled
+		span.TraceState = strings.Join(r.Header.Values("tracestate"), ",")
+	} else {
+		span.TraceID, span.Sampled = newTraceID(), true
+	}
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.target", r.URL.RequestURI())
+	span.SetAttribute("http.host", r.Host)
+	span.SetAttribute("http.scheme", scheme(r))
+	return span
+}
+
+// scheme returns the scheme the client used, see IsHTTPS.
+func scheme(r *http.Request) string {
+	if IsHTTPS(r) {
+		return "https"
+	}
+	return "http"
+}
+
+// queue hands span to the export goroutine, dropping it if the queue is full
+// or the tracer was shut down.
+func (t *tracer) queue(span *Span) {
+
+	select {
+	case <-t.done:
+	case t.spans <- span:
+	default:
+		t.log.Debug("Dropped span %s of trace %s: export queue is full", span.SpanID, span.TraceID)
+	}
+}
+
+func (t *tracer) run() {
+
+	defer close(t.stopped)
+	ticker := time.NewTicker(traceFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, traceBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := t.exporter.Export(ctx, batch); err != nil {
+			t.log.Info("Could not export %d spans: %v", len(batch), err)
+		}
+		cancel()
+		batch = make([]*Span, 0, traceBatchSize)
+	}
+
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) == traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-t.done:
+			for {
+				select {
+				case span := <-t.spans:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// shutdown stops the export goroutine and waits until the queued spans are
+// exported or ctx is done.
+func (t *tracer) shutdown(ctx context.Context) {
+
+	t.once.Do(func() { close(t.done) })
+	select {
+	case <-t.stopped:
+	case <-ctx.Done():
+	}
+}
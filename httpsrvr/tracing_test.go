@@ -0,0 +1,120 @@
+package httpsrvr
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+
+	tests := []struct {
+		value   string
+		ok      bool
+		sampled bool
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", false, false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"", false, false},
+	}
+	for _, test := range tests {
+		_, _, sampled, ok := parseTraceparent(test.value)
+		if ok != test.ok || sampled != test.sampled {
+			t.Errorf("parseTraceparent(%q) = sampled %v, ok %v; want %v, %v", test.value, sampled, ok, test.sampled, test.ok)
+		}
+	}
+}
+
+func TestTracingOTLP(t *testing.T) {
+
+	// collector stand-in
+	requests := make(chan otlpRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req otlpRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("collector: %v", err)
+		}
+		requests <- req
+	}))
+	defer collector.Close()
+
+	s := NewServer(0, false).WithTracing(NewOTLPExporter(collector.URL+"/v1/traces", "test"))
+	var downstream http.Header
+	s.Register("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		downstream = http.Header{}
+		InjectTrace(r.Context(), downstream)
+	}).Name("user")
+
+	req := httptest.NewRequest("GET", "/users/42", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("tracestate", "vendor=value")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	s.tracer.shutdown(context.Background())
+
+	req2 := <-requests
+	if len(req2.ResourceSpans) != 1 || len(req2.ResourceSpans[0].ScopeSpans) != 1 || len(req2.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("collector got %+v, want a single span", req2)
+	}
+	span := req2.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.Name != "user" || span.Kind != SpanKindServer || span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || span.ParentSpanID != "00f067aa0ba902b7" || span.TraceState != "vendor=value" {
+		t.Errorf("collector got span %+v", span)
+	}
+	if want := "00-4bf92f3577b34da6a3ce929d0e0e4736-" + span.SpanID + "-01"; downstream.Get("traceparent") != want {
+		t.Errorf("injected traceparent %q, want %q", downstream.Get("traceparent"), want)
+	}
+	if downstream.Get("tracestate") != "vendor=value" {
+		t.Errorf("injected tracestate %q, want %q", downstream.Get("tracestate"), "vendor=value")
+	}
+}
+
+type discardExporter struct{}
+
+func (discardExporter) Export(context.Context, []*Span) error { return nil }
+
+func TestTracingScheme(t *testing.T) {
+
+	s := NewServer(0, false).TrustProxies("10.0.0.0/8").WithTracing(discardExporter{})
+	defer s.tracer.shutdown(context.Background())
+	var scheme string
+	s.Register("/", func(w http.ResponseWriter, r *http.Request) {
+		scheme = SpanFromContext(r.Context()).Attributes["http.scheme"]
+	})
+
+	tests := []struct {
+		remote, proto string
+		tls           bool
+		want          string
+	}{
+		{"10.1.2.3:5000", "", false, "http"},
+		{"10.1.2.3:5000", "", true, "https"},
+		{"10.1.2.3:5000", "https", false, "https"},
+		{"10.1.2.3:5000", "http", true, "https"},
+		// untrusted clients can't claim HTTPS
+		{"192.0.2.1:5000", "https", false, "http"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = test.remote
+		if test.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", test.proto)
+		}
+		if test.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		scheme = ""
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		if scheme != test.want {
+			t.Errorf("%s %q tls %v: got http.scheme %q, want %q", test.remote, test.proto, test.tls, scheme, test.want)
+		}
+	}
+}
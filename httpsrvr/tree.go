@@ -0,0 +1,237 @@
+package httpsrvr
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// tree is a radix tree over path segments shared by a root dispatcher and all
+// dispatchers registered beneath it. Chains of literal segments without a route
+// in between are compressed into a single node, parameter nodes match exactly
+// one segment.
+type tree struct {
+	root *node
+}
+
+type node struct {
+	path   string           // literal segments each starting with '/', "" for the root and parameter nodes
+	param  *pathParam       // set for parameter nodes
+	static map[string]*node // literal children by their first segment
+	params []*node          // parameter children in order of precedence
+	route  *dispatcher
+}
+
+// insert returns the node for the given route segments, creating nodes as necessary.
+func (n *node) insert(segments []string) *node {
+
+	if len(segments) == 0 {
+		return n
+	}
+
+	param, err := parseParam(segments[0])
+	if err != nil {
+		panic(err)
+	}
+	if param != nil {
+		return n.paramChild(param).insert(segments[1:])
+	}
+
+	// the run of literal segments which may be compressed into one node
+	run := 1
+	for run < len(segments) && !isParam(segments[run]) {
+		run++
+	}
+
+	if n.static == nil {
+		n.static = make(map[string]*node)
+	}
+	child, ok := n.static[segments[0]]
+	if !ok {
+		child = &node{path: "/" + strings.Join(segments[:run], "/")}
+		n.static[segments[0]] = child
+		return child.insert(segments[run:])
+	}
+
+	// number of leading segments shared with the existing child
+	existing := strings.Split(child.path[1:], "/")
+	common := 1
+	for common < len(existing) && common < run && existing[common] == segments[common] {
+		common++
+	}
+	if common < len(existing) {
+		// split child below the common segments
+		mid := &node{path: "/" + strings.Join(existing[:common], "/"), static: make(map[string]*node)}
+		child.path = child.path[len(mid.path):]
+		mid.static[existing[common]] = child
+		n.static[segments[0]] = mid
+		child = mid
+	}
+	return child.insert(segments[common:])
+}
+
+func (n *node) paramChild(param *pathParam) *node {
+
+	for _, c := range n.params {
+		if c.param.pattern == param.pattern {
+			if c.param.name != param.name {
+				panic("httpsrvr: parameter " + param.String() + " conflicts with " + c.param.String())
+			}
+			return c
+		}
+	}
+	child := &node{param: param}
+
+	// constrained parameters are tried in registration order before plain ones
+	i := len(n.params)
+	if param.constrained() {
+		for i > 0 && !n.params[i-1].param.constrained() {
+			i--
+		}
+	}
+	n.params = append(n.params, nil)
+	copy(n.params[i+1:], n.params[i:])
+	n.params[i] = child
+	return child
+}
+
+// match returns the route of the deepest node matching a segment prefix of p
+// and the unmatched remainder of p. Parameters are appended to params.
+// match does not allocate unless params has to grow.
+func (n *node) match(p string, params *PathParams) (*dispatcher, string) {
+
+	route, end := n.lookup(p, 0, params)
+	if route == nil {
+		route, end = n.route, 0
+	}
+	if end == len(p) {
+		return route, "/"
+	}
+	return route, p[end:]
+}
+
+// lookup returns the route of the deepest node below n matching a segment
+// prefix of p[pos:] and the end of that prefix, or nil if there is none.
+// A literal child is tried first, parameter children in order of precedence
+// only if the literal subtree has no matching route. Parameters other than
+// the ones leading to the returned route are removed from params again.
+func (n *node) lookup(p string, pos int, params *PathParams) (*dispatcher, int) {
+
+	if pos >= len(p) {
+		return nil, 0
+	}
+	// p[pos] is '/', the next segment is p[pos+1:next]
+	next := strings.IndexByte(p[pos+1:], '/')
+	if next < 0 {
+		next = len(p)
+	} else {
+		next += pos + 1
+	}
+	segment := p[pos+1 : next]
+
+	if child, ok := n.static[segment]; ok {
+		rest := p[pos:]
+		if strings.HasPrefix(rest, child.path) && (len(rest) == len(child.path) || rest[len(child.path)] == '/') {
+			if route, end := child.lookup(p, pos+len(child.path), params); route != nil {
+				return route, end
+			}
+			if child.route != nil {
+				return child.route, pos + len(child.path)
+			}
+		}
+	}
+
+	if segment == "" {
+		// a parameter never matches the empty segment of a trailing slash
+		return nil, 0
+	}
+	nparams := len(*params)
+	for _, child := range n.params {
+		if !child.param.match(segment) {
+			continue
+		}
+		*params = append(*params, PathParam{child.param.name, segment})
+		if route, end := child.lookup(p, next, params); route != nil {
+			return route, end
+		}
+		if child.route != nil {
+			return child.route, next
+		}
+		*params = (*params)[:nparams]
+	}
+	return nil, 0
+}
+
+// walk calls fn for every route in n and its descendants, literal children
+// sorted before parameters in order of precedence.
+func (n *node) walk(fn func(*dispatcher)) {
+
+	if n.route != nil {
+		fn(n.route)
+	}
+	segments := make([]string, 0, len(n.static))
+	for segment := range n.static {
+		segments = append(segments, segment)
+	}
+	sort.Strings(segments)
+	for _, segment := range segments {
+		n.static[segment].walk(fn)
+	}
+	for _, child := range n.params {
+		child.walk(fn)
+	}
+}
+
+// compile links every dispatcher to its nearest parent dispatcher and
+// precomputes its middleware chain. It is called after every change of the tree.
+func (t *tree) compile() {
+
+	var parents []*dispatcher
+	t.root.walk(func(d *dispatcher) {
+		for len(parents) > 0 && !isPrefix(parents[len(parents)-1].pattern, d.pattern) {
+			parents = parents[:len(parents)-1]
+		}
+		d.parent = nil
+		if len(parents) > 0 {
+			d.parent = parents[len(parents)-1]
+		}
+		parents = append(parents, d)
+
+		d.allow = strings.Join(d.Allowed(), ", ")
+		d.serve = d.chain(http.HandlerFunc(d.serveMethod))
+	})
+}
+
+// isPrefix reports whether pattern lies beneath or at prefix.
+func isPrefix(prefix, pattern string) bool {
+	return prefix == "/" || pattern == prefix || strings.HasPrefix(pattern, prefix+"/")
+}
+
+func isParam(segment string) bool {
+	return strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}")
+}
+
+// splitPattern cleans pattern and returns its segments.
+func splitPattern(pattern string) (string, []string) {
+
+	pattern = path.Clean("/" + pattern)
+	if pattern == "/" {
+		return pattern, nil
+	}
+	return pattern, strings.Split(pattern[1:], "/")
+}
+
+// cleanPath cleans p only if necessary to keep matching allocation free.
+func cleanPath(p string) string {
+
+	if p == "" || p[0] != '/' || strings.Contains(p, "//") || strings.Contains(p, "/./") || strings.Contains(p, "/../") ||
+		strings.HasSuffix(p, "/.") || strings.HasSuffix(p, "/..") {
+		clean := path.Clean("/" + p)
+		if strings.HasSuffix(p, "/") && clean != "/" {
+			clean += "/"
+		}
+		return clean
+	}
+	return p
+}
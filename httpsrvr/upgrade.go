@@ -0,0 +1,189 @@
+package httpsrvr
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ihleven/errors"
+)
+
+// Environment passed to the new process of a graceful upgrade.
+const (
+	envListenFDs   = "HTTPSRVR_LISTEN_FDS"   // number of inherited listeners starting at fd 3
+	envListenNames = "HTTPSRVR_LISTEN_NAMES" // their keys separated by newlines
+	envReadyFD     = "HTTPSRVR_READY_FD"     // pipe to signal readiness on
+)
+
+// upgradeTimeout is how long the running process waits for the new one to get ready.
+var upgradeTimeout = 30 * time.Second
+
+// UpgradeOn sets the signals triggering a graceful upgrade, SIGUSR2 by default.
+// Passing SIGHUP makes it upgrade instead of calling Reload.
+func (s *httpServer) UpgradeOn(signals ...os.Signal) *httpServer {
+
+	s.upgradeSignals = signals
+	return s
+}
+
+// Upgrade starts the executable of the running process with the same arguments
+// and hands over all listening sockets. When the new process signals that it
+// serves, the running server stops accepting connections, drains in-flight
+// requests and Run returns. If the new process fails to get ready within
+// upgradeTimeout, it is killed and the running server carries on.
+func (s *httpServer) Upgrade() error {
+
+	if runtime.GOOS == "windows" {
+		return errors.New("graceful upgrade is not supported on windows")
+	}
+	if len(s.bound) == 0 {
+		return errors.New("server is not serving")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "cannot determine executable")
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	names := make([]string, 0, len(s.bound))
+	for _, l := range s.bound {
+		fl, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return errors.New("cannot hand over listener %v of type %T", l, l.ln)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return errors.Wrap(err, "cannot get file of listener %v", l)
+		}
+		files = append(files, f)
+		names = append(names, l.key())
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return errors.Wrap(err, "cannot create readiness pipe")
+	}
+	defer ready.Close()
+	files = append(files, readyWriter)
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(),
+		envListenFDs+"="+strconv.Itoa(len(names)),
+		envListenNames+"="+strings.Join(names, "\n"),
+		envReadyFD+"="+strconv.Itoa(3+len(names)),
+	)
+
+	s.log.Info(" +++ Upgrading: starting %s", executable)
+	s.notify("RELOADING=1")
+	err = cmd.Start()
+	for _, l := range s.bound {
+		if err := setNonblock(l.ln); err != nil {
+			s.log.Info("Cannot restore non-blocking mode of %v: %v", l, err)
+		}
+	}
+	if err != nil {
+		s.notify("READY=1")
+		return errors.Wrap(err, "cannot start %s", executable)
+	}
+	// only the child keeps the write end open
+	readyWriter.Close()
+	files = files[:len(files)-1]
+
+	signalled := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1)
+		_, err := ready.Read(buf)
+		signalled <- err
+	}()
+
+	select {
+	case err = <-signalled:
+	case <-time.After(upgradeTimeout):
+		err = errors.New("timeout after %v", upgradeTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		go cmd.Wait()
+		s.notify("READY=1")
+		return errors.Wrap(err, "new process %d did not get ready", cmd.Process.Pid)
+	}
+
+	s.log.Info(" +++ Upgrade: process %d took over, draining", cmd.Process.Pid)
+	s.notify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid))
+	for _, l := range s.bound {
+		// the socket file now belongs to the new process
+		if ul, ok := l.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	go cmd.Process.Release()
+	close(s.handover)
+	return nil
+}
+
+// key identifies a listener across processes.
+func (l *listener) key() string {
+	return l.network + " " + l.addr
+}
+
+// inheritedListeners returns the listeners handed over by the previous process
+// of a graceful upgrade by key and clears the environment passing them.
+func inheritedListeners() (map[string]net.Listener, error) {
+
+	count := os.Getenv(envListenFDs)
+	if count == "" {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv(envListenNames), "\n")
+	os.Unsetenv(envListenFDs)
+	os.Unsetenv(envListenNames)
+
+	n, err := strconv.Atoi(count)
+	if err != nil || n != len(names) {
+		return nil, errors.New("invalid inherited listeners %q: %q", count, names)
+	}
+
+	listeners := make(map[string]net.Listener, n)
+	for i, name := range names {
+		f := os.NewFile(uintptr(3+i), name)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot use inherited listener %q", name)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
+
+// signalUpgradeReady tells the previous process of a graceful upgrade that
+// this one serves.
+func signalUpgradeReady() {
+
+	fd := os.Getenv(envReadyFD)
+	if fd == "" {
+		return
+	}
+	os.Unsetenv(envReadyFD)
+
+	n, err := strconv.Atoi(fd)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(n), "ready")
+	f.Write([]byte{1})
+	f.Close()
+}
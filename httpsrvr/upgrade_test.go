@@ -0,0 +1,137 @@
+package httpsrvr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// envTestUpgrade tells the test binary started by Upgrade how to behave as the
+// new process: "ready" serves the inherited listeners, "hang" never gets ready.
+const envTestUpgrade = "HTTPSRVR_TEST_UPGRADE"
+
+func TestMain(m *testing.M) {
+
+	if os.Getenv(envListenFDs) != "" {
+		upgradedProcess()
+	}
+	if os.Getenv(envTestSystemd) != "" {
+		activatedProcess()
+	}
+	os.Exit(m.Run())
+}
+
+// upgradedProcess is run by the test binary started by Upgrade. It answers a
+// single request on the inherited listeners and exits.
+func upgradedProcess() {
+
+	if os.Getenv(envTestUpgrade) == "hang" {
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	}
+
+	listeners, err := inheritedListeners()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	done := make(chan struct{}, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "new")
+		done <- struct{}{}
+	})}
+	for _, ln := range listeners {
+		go server.Serve(ln)
+	}
+	signalUpgradeReady()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+	}
+	server.Shutdown(context.Background())
+	os.Exit(0)
+}
+
+func serveForUpgrade(t *testing.T) (*httpServer, string) {
+
+	t.Helper()
+	s := NewServer(0, false).Listen("127.0.0.1:0", nil)
+	s.Register("/", func(w http.ResponseWriter, r *http.Request) { fmt.Fprint(w, "old") })
+	listeners, err := s.bindListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.bound = listeners
+	s.server = &http.Server{Handler: s}
+	go s.serve(listeners)
+	return s, "http://" + listeners[0].ln.Addr().String() + "/"
+}
+
+func TestUpgrade(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("graceful upgrade is not supported on windows")
+	}
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	s, url := serveForUpgrade(t)
+	if body := get(t, client, url); body != "old" {
+		t.Fatalf("got %q before upgrade", body)
+	}
+
+	os.Setenv(envTestUpgrade, "ready")
+	defer os.Unsetenv(envTestUpgrade)
+	if err := s.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-s.handover:
+	default:
+		t.Error("handover not signalled")
+	}
+
+	// the new process keeps serving the socket after the old one stopped
+	s.server.Shutdown(context.Background())
+	if body := get(t, client, url); body != "new" {
+		t.Errorf("got %q after upgrade", body)
+	}
+}
+
+func TestUpgradeTimeout(t *testing.T) {
+
+	if runtime.GOOS == "windows" {
+		t.Skip("graceful upgrade is not supported on windows")
+	}
+	defer func(timeout time.Duration) { upgradeTimeout = timeout }(upgradeTimeout)
+	upgradeTimeout = 200 * time.Millisecond
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	s, url := serveForUpgrade(t)
+	defer s.server.Shutdown(context.Background())
+
+	os.Setenv(envTestUpgrade, "hang")
+	defer os.Unsetenv(envTestUpgrade)
+	if err := s.Upgrade(); err == nil {
+		t.Fatal("no error although the new process did not get ready")
+	}
+	select {
+	case <-s.handover:
+		t.Error("handover signalled")
+	default:
+	}
+	if body := get(t, client, url); body != "old" {
+		t.Errorf("got %q after failed upgrade", body)
+	}
+}
+
+func TestUpgradeNotServing(t *testing.T) {
+
+	if err := NewServer(0, false).Upgrade(); err == nil {
+		t.Error("no error upgrading a server that does not serve")
+	}
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package httpsrvr
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+var defaultUpgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// setNonblock puts the socket of ln back into non-blocking mode. Starting a
+// process with its file switches it off for all descriptors of the socket,
+// leaving Accept stuck in a system call that Close cannot interrupt.
+func setNonblock(ln net.Listener) error {
+
+	sc, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var nonblockErr error
+	if err := raw.Control(func(fd uintptr) {
+		nonblockErr = syscall.SetNonblock(int(fd), true)
+	}); err != nil {
+		return err
+	}
+	return nonblockErr
+}
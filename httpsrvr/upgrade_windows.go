@@ -0,0 +1,12 @@
+package httpsrvr
+
+import (
+	"net"
+	"os"
+)
+
+var defaultUpgradeSignals []os.Signal
+
+func setNonblock(ln net.Listener) error {
+	return nil
+}
@@ -47,32 +47,74 @@ type AccessLogger struct {
 	Format string
 }
 
+// AccessEntry is a request as written to the access log by AccessLogger.Log.
+type AccessEntry struct {
+	ReqNum     uint64
+	ReqID      string
+	Start      time.Time
+	RemoteAddr string
+	Username   string
+	Method     string
+	Host       string // logged in front of the entry unless empty
+	URI        string
+	Proto      string
+	Status     int
+	Size       int
+	// Uncompressed is the body size before compression, logged after Size
+	// unless zero, e.g. by Access
+	Uncompressed int
+	Duration     time.Duration
+	Referer      string
+	Agent        string
+}
+
+// Access logs a request without host, see Log.
 func (l AccessLogger) Access(reqNum uint64, reqID string, start time.Time, remoteAddr, username, method, uri, proto string, status, size int, duration time.Duration, referer, agent string) {
-	// username := "-"
 
-	// if req.URL.User != nil {
-	// 	if name := req.URL.User.Username(); name != "" {
-	// 		username = name
-	// 	}
-	// }
+	l.Log(AccessEntry{
+		ReqNum:     reqNum,
+		ReqID:      reqID,
+		Start:      start,
+		RemoteAddr: remoteAddr,
+		Username:   username,
+		Method:     method,
+		URI:        uri,
+		Proto:      proto,
+		Status:     status,
+		Size:       size,
+		Duration:   duration,
+		Referer:    referer,
+		Agent:      agent,
+	})
+}
+
+// Log logs a request.
+func (l AccessLogger) Log(e AccessEntry) {
 
 	switch l.Format {
 	case "CombineLoggerType":
-		fmt.Fprintln(os.Stdout, strings.Join([]string{
-			" === access logger === ",
-			remoteAddr,
+		fields := []string{" === access logger === "}
+		if e.Host != "" {
+			fields = append(fields, e.Host)
+		}
+		size := strconv.Itoa(e.Size)
+		if e.Uncompressed != 0 {
+			size += " " + strconv.Itoa(e.Uncompressed)
+		}
+		fmt.Fprintln(os.Stdout, strings.Join(append(fields,
+			e.RemoteAddr,
 			"-",
-			reqID, //strconv.Itoa(int(reqNum)),
-			"[" + start.Format(timeFormat) + "]",
-			`"` + method,
-			uri,
-			proto + `"`,
-			strconv.Itoa(status),
-			strconv.Itoa(size),
-			duration.String(),
-			`"` + referer + `"`,
-			`"` + agent + `"`,
-		}, " "))
+			e.ReqID, //strconv.Itoa(int(reqNum)),
+			"["+e.Start.Format(timeFormat)+"]",
+			`"`+e.Method,
+			e.URI,
+			e.Proto+`"`,
+			strconv.Itoa(e.Status),
+			size,
+			e.Duration.String(),
+			`"`+e.Referer+`"`,
+			`"`+e.Agent+`"`,
+		), " "))
 
 	}
 }